@@ -1,27 +1,56 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	goruntime "runtime"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/patrickjm/api-cli/internal/config"
+	"github.com/patrickjm/api-cli/internal/oauth"
+	"github.com/patrickjm/api-cli/internal/output"
 	"github.com/patrickjm/api-cli/internal/provider"
+	"github.com/patrickjm/api-cli/internal/query"
+	"github.com/patrickjm/api-cli/internal/request"
 	"github.com/patrickjm/api-cli/internal/runtime"
 	"github.com/patrickjm/api-cli/internal/secret"
+	"github.com/patrickjm/api-cli/internal/snippet"
 	"github.com/spf13/cobra"
 )
 
 var (
-	configDir string
-	profile   string
-	timeout   time.Duration
-	jsonOut   bool
-	version   = "dev"
+	configDir     string
+	profile       string
+	timeout       time.Duration
+	jsonOut       bool
+	outFile       string
+	allowPaths    []string
+	verbose       bool
+	debug         bool
+	allPages      bool
+	maxItems      int
+	noCache       bool
+	cacheOnly     bool
+	dryRun        bool
+	asFormat      string
+	inlineSecrets bool
+	recordPath    string
+	replayPath    string
+	matchRules    []string
+	queryExpr     string
+	rawOut        bool
+	outputFormat  string
+	columns       []string
+	version       = "dev"
 )
 
 func Execute() error {
@@ -56,13 +85,38 @@ func newRootCmd() *cobra.Command {
 	cmd.PersistentFlags().DurationVarP(&timeout, "timeout", "t", 20*time.Second, "request timeout")
 	cmd.PersistentFlags().BoolVarP(&jsonOut, "json", "j", false, "emit JSON output")
 	cmd.PersistentFlags().StringArrayP("param", "s", nil, "request param key=value")
+	cmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "log retries, rate limit waits and console.log output to stderr")
+	cmd.PersistentFlags().BoolVar(&debug, "debug", false, "also log every request and console.debug output to stderr, with secrets redacted")
+	cmd.Flags().StringVarP(&outFile, "out-file", "O", "", "write the result to a file instead of stdout")
+	cmd.Flags().BoolVar(&allPages, "all", false, "fetch every page of a paginated command and print items as NDJSON")
+	cmd.Flags().IntVar(&maxItems, "max-items", 0, "stop after this many items (implies --all)")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "skip cached responses and always fetch")
+	cmd.Flags().BoolVar(&cacheOnly, "cache-only", false, "serve responses from the cache only, never the network")
+	cmd.MarkFlagsMutuallyExclusive("no-cache", "cache-only")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print each request with secrets masked instead of sending it")
+	cmd.Flags().StringVar(&asFormat, "as", "", "print each request as a "+strings.Join(snippet.Formats, ", ")+" snippet instead of sending it")
+	cmd.Flags().BoolVar(&inlineSecrets, "inline-secrets", false, "put secret values in --as snippets instead of <name> placeholders")
+	cmd.Flags().StringVar(&recordPath, "record", "", "save every request and response, secrets scrubbed, to a cassette file")
+	cmd.Flags().StringVar(&replayPath, "replay", "", "answer requests from a cassette file instead of the network")
+	cmd.Flags().StringSliceVar(&matchRules, "match", nil, "how --replay matches requests: method, url, path, query, body, headers or header:NAME (default method,url)")
+	cmd.MarkFlagsMutuallyExclusive("record", "replay")
+	cmd.Flags().StringVarP(&queryExpr, "query", "q", "", "jq filter applied to the JSON result, e.g. '.choices[0].message.content'")
+	cmd.Flags().BoolVar(&rawOut, "raw", false, "print the whole result, ignoring the command's defaultQuery")
+	cmd.MarkFlagsMutuallyExclusive("query", "raw")
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "", "print the JSON result as "+strings.Join(output.Formats, ", "))
+	cmd.Flags().StringSliceVar(&columns, "columns", nil, "fields to show, as dot paths (default the command's columns); implies -o table")
+	cmd.Flags().StringArrayVar(&allowPaths, "allow-path", nil, "directory scripts may read and write besides the working dir")
 
 	cmd.AddCommand(newInstallCmd())
 	cmd.AddCommand(newProvidersCmd())
 	cmd.AddCommand(newInspectCmd())
+	cmd.AddCommand(newTestCmd())
 	cmd.AddCommand(newEnvCmd())
 	cmd.AddCommand(newProfileCmd())
 	cmd.AddCommand(newSecretCmd())
+	cmd.AddCommand(newNetworkCmd())
+	cmd.AddCommand(newAuthCmd())
+	cmd.AddCommand(newCacheCmd())
 	return cmd
 }
 
@@ -70,6 +124,25 @@ func runProvider(cmd *cobra.Command, args []string) error {
 	if len(args) == 0 {
 		return cmd.Usage()
 	}
+	cmd.SilenceUsage = true
+	if asFormat != "" && !slices.Contains(snippet.Formats, asFormat) {
+		return fmt.Errorf("unknown --as format %q (want %s)", asFormat, strings.Join(snippet.Formats, ", "))
+	}
+	format := outputFormat
+	if format == "" && len(columns) > 0 {
+		format = output.Table
+	}
+	if format != "" && !slices.Contains(output.Formats, format) {
+		return fmt.Errorf("unknown --output format %q (want %s)", format, strings.Join(output.Formats, ", "))
+	}
+	capture := dryRun || asFormat != ""
+	var q *query.Query
+	if queryExpr != "" {
+		var err error
+		if q, err = query.Parse(queryExpr); err != nil {
+			return err
+		}
+	}
 	providerName, commandName, paramArgs := parseProviderArgs(args)
 	params, err := parseParams(paramArgs)
 	if err != nil {
@@ -105,19 +178,90 @@ func runProvider(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	env := profiles.Profiles[resolvedProfile].Env
+	prof := profiles.Profiles[resolvedProfile]
+
+	cacheMode := request.CacheDefault
+	switch {
+	case noCache:
+		cacheMode = request.CacheBypass
+	case cacheOnly:
+		cacheMode = request.CacheOnly
+	}
+
+	// --all streams items as NDJSON while pages arrive, so the output file is
+	// opened up front. Other output formats need every item before they can
+	// lay out columns, so those items are collected instead.
+	all := allPages || maxItems > 0
+	items := []json.RawMessage{}
+	itemOut := cmd.OutOrStdout()
+	if outFile != "" && all && !capture {
+		f, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		itemOut = f
+	}
 
 	result, err := runtime.Execute(script, runtime.ExecOptions{
-		Provider: providerName,
-		Profile:  resolvedProfile,
-		Command:  commandName,
-		Params:   params,
-		Env:      env,
-		Timeout:  timeout,
+		Provider:      providerName,
+		Profile:       resolvedProfile,
+		Command:       commandName,
+		Params:        params,
+		Env:           prof.Env,
+		Timeout:       timeout,
+		Stdout:        cmd.OutOrStdout(),
+		ModuleDir:     base,
+		AllowPaths:    allowPaths,
+		Retry:         prof.Retry,
+		RateLimitPath: config.RateLimitPath(base, providerName, resolvedProfile),
+		CacheDir:      config.CacheDir(base, providerName),
+		CacheMode:     cacheMode,
+		Network:       prof.Network,
+		Verbose:       verbose,
+		Debug:         debug,
+		DryRun:        dryRun,
+		As:            asFormat,
+		InlineSecrets: inlineSecrets,
+		Record:        recordPath,
+		Replay:        replayPath,
+		Match:         matchRules,
+		Stderr:        cmd.ErrOrStderr(),
+		All:           all,
+		MaxItems:      maxItems,
+		OnItem: func(item json.RawMessage) error {
+			if format != "" {
+				if q != nil {
+					var err error
+					if item, err = queryDoc(q, string(item)); err != nil {
+						return err
+					}
+				}
+				if format != output.NDJSON {
+					items = append(items, item)
+					return nil
+				}
+				return output.Render(itemOut, format, item, columns)
+			}
+			if q == nil {
+				_, err := fmt.Fprintf(itemOut, "%s\n", item)
+				return err
+			}
+			out, err := runQuery(q, string(item))
+			if err != nil {
+				return err
+			}
+			_, err = itemOut.Write(out)
+			return err
+		},
 	})
 	if err != nil {
 		return err
 	}
+	// Results built from synthetic responses would only mislead.
+	if capture {
+		return nil
+	}
 
 	if result.Status >= 400 {
 		if result.Body != "" {
@@ -125,24 +269,119 @@ func runProvider(cmd *cobra.Command, args []string) error {
 		}
 		return fmt.Errorf("request failed with status %d", result.Status)
 	}
-
-	if jsonOut {
-		if result.JSON != "" {
-			fmt.Fprintln(cmd.OutOrStdout(), result.JSON)
+	if all {
+		if format == "" || format == output.NDJSON {
 			return nil
 		}
+		doc, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		return output.Render(itemOut, format, doc, outputColumns(format, result))
 	}
-	if result.Body != "" {
-		fmt.Fprintln(cmd.OutOrStdout(), result.Body)
+
+	if q == nil && !rawOut && result.DefaultQuery != "" && result.JSON != "" {
+		if q, err = query.Parse(result.DefaultQuery); err != nil {
+			return fmt.Errorf("%s.%s: defaultQuery: %w", providerName, commandName, err)
+		}
 	}
-	return nil
+
+	var out []byte
+	switch {
+	case format != "":
+		doc := []byte(result.JSON)
+		if result.JSON == "" {
+			return fmt.Errorf("--output %s needs a JSON result", format)
+		}
+		if q != nil {
+			if doc, err = queryDoc(q, result.JSON); err != nil {
+				return err
+			}
+		}
+		var buf bytes.Buffer
+		if err := output.Render(&buf, format, doc, outputColumns(format, result)); err != nil {
+			return err
+		}
+		out = buf.Bytes()
+	case q != nil:
+		if result.JSON == "" {
+			return errors.New("--query needs a JSON result")
+		}
+		if out, err = runQuery(q, result.JSON); err != nil {
+			return err
+		}
+	case result.Binary != nil:
+		out = result.Binary
+	case jsonOut && result.JSON != "":
+		out = []byte(result.JSON + "\n")
+	case result.Body != "":
+		out = []byte(result.Body + "\n")
+	}
+	if outFile != "" {
+		return os.WriteFile(outFile, out, 0o644)
+	}
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
+
+// outputColumns returns --columns, or the command's own columns for
+// formats laid out in columns.
+func outputColumns(format string, result *runtime.ExecResult) []string {
+	if len(columns) > 0 || !output.Tabular(format) {
+		return columns
+	}
+	return result.Columns
+}
+
+// runQuery applies q to a JSON document and prints one output per line.
+// Strings are printed as plain text unless --json is set.
+func runQuery(q *query.Query, doc string) ([]byte, error) {
+	values, err := queryValues(q, doc)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, value := range values {
+		if s, ok := value.(string); ok && !jsonOut {
+			buf.WriteString(s + "\n")
+			continue
+		}
+		if err := enc.Encode(value); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// queryDoc applies q to a JSON document and returns its outputs as one JSON
+// document: the only output, or an array of all of them.
+func queryDoc(q *query.Query, doc string) ([]byte, error) {
+	values, err := queryValues(q, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 1 {
+		return json.Marshal(values[0])
+	}
+	return json.Marshal(values)
+}
+
+func queryValues(q *query.Query, doc string) ([]any, error) {
+	v, err := query.Decode([]byte(doc))
+	if err != nil {
+		return nil, fmt.Errorf("result is not JSON: %w", err)
+	}
+	return q.Run(v)
 }
 
 func newInstallCmd() *cobra.Command {
 	var name string
+	var lib bool
 	cmd := &cobra.Command{
 		Use:   "install <source>",
-		Short: "install a provider script",
+		Short: "install a provider script or shared library module",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			source := args[0]
@@ -164,14 +403,22 @@ func newInstallCmd() *cobra.Command {
 				return err
 			}
 			path := config.ProviderPath(base, name)
+			if lib {
+				path = config.LibPath(base, name)
+			}
 			if err := provider.SaveProvider(path, contents); err != nil {
 				return err
 			}
+			if lib {
+				fmt.Fprintf(cmd.OutOrStdout(), "installed lib/%s.js\n", name)
+				return nil
+			}
 			fmt.Fprintf(cmd.OutOrStdout(), "installed %s\n", name)
 			return nil
 		},
 	}
-	cmd.Flags().StringVarP(&name, "name", "n", "", "provider name")
+	cmd.Flags().StringVarP(&name, "name", "n", "", "provider or module name")
+	cmd.Flags().BoolVar(&lib, "lib", false, "install as a shared library module under lib/")
 	return cmd
 }
 
@@ -198,9 +445,9 @@ func newProvidersCmd() *cobra.Command {
 
 func newInspectCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "inspect <provider>",
+		Use:   "inspect <provider> [command]",
 		Short: "list commands for a provider",
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			name := args[0]
 			base, err := config.BaseDir(configDir)
@@ -211,13 +458,44 @@ func newInspectCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			commands, err := runtime.DescribeCommands(script)
+			commands, err := runtime.DescribeCommands(script, base)
 			if err != nil {
 				return err
 			}
+			if len(args) == 2 {
+				var found []runtime.CommandDoc
+				for _, c := range commands {
+					if c.Name == args[1] {
+						found = append(found, c)
+					}
+				}
+				if len(found) == 0 {
+					return fmt.Errorf("command not found: %s", args[1])
+				}
+				commands = found
+			}
+			if jsonOut {
+				b, err := json.MarshalIndent(commands, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), string(b))
+				return nil
+			}
 			for _, c := range commands {
+				if len(args) == 2 {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", c.Name, c.Desc)
+					for _, a := range c.Args {
+						fmt.Fprintln(cmd.OutOrStdout(), formatArg(a))
+					}
+					continue
+				}
 				if len(c.Args) > 0 {
-					fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\n", c.Name, c.Desc, strings.Join(c.Args, ","))
+					names := make([]string, 0, len(c.Args))
+					for _, a := range c.Args {
+						names = append(names, a.Name)
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\t%s\n", c.Name, c.Desc, strings.Join(names, ","))
 				} else {
 					fmt.Fprintf(cmd.OutOrStdout(), "%s\t%s\n", c.Name, c.Desc)
 				}
@@ -227,6 +505,136 @@ func newInspectCmd() *cobra.Command {
 	}
 }
 
+func newTestCmd() *cobra.Command {
+	var junitPath string
+	cmd := &cobra.Command{
+		Use:   "test <provider>",
+		Short: "run a provider's *.test.js files against mocked fetch",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			name := args[0]
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			providerPath := config.ProviderPath(base, name)
+			script, err := os.ReadFile(providerPath)
+			if err != nil {
+				return fmt.Errorf("provider not found: %s", name)
+			}
+			dir := filepath.Dir(providerPath)
+			paths, err := filepath.Glob(filepath.Join(dir, name+".test.js"))
+			if err != nil {
+				return err
+			}
+			more, err := filepath.Glob(filepath.Join(dir, name+".*.test.js"))
+			if err != nil {
+				return err
+			}
+			paths = append(paths, more...)
+			slices.Sort(paths)
+			if len(paths) == 0 {
+				return fmt.Errorf("no test files for %s (expected %s.test.js next to the provider)", name, name)
+			}
+
+			out := cmd.OutOrStdout()
+			var files []runtime.TestFile
+			var passed, failed, skipped int
+			for _, path := range paths {
+				file := runtime.TestFile{Name: filepath.Base(path)}
+				source, err := os.ReadFile(path)
+				if err == nil {
+					file.Results, err = runtime.RunTests(source, file.Name, runtime.TestOptions{
+						Provider:  name,
+						Script:    script,
+						ModuleDir: base,
+						Timeout:   timeout,
+						Stderr:    cmd.ErrOrStderr(),
+					})
+				}
+				file.Err = err
+				files = append(files, file)
+				fmt.Fprintln(out, file.Name)
+				if err != nil {
+					failed++
+					fmt.Fprintf(out, "  FAIL  %v\n", err)
+					continue
+				}
+				for _, r := range file.Results {
+					title := r.Name
+					if r.Suite != "" {
+						title = r.Suite + " > " + r.Name
+					}
+					switch {
+					case r.Skipped:
+						skipped++
+						fmt.Fprintf(out, "  SKIP  %s\n", title)
+					case r.Failure != "":
+						failed++
+						fmt.Fprintf(out, "  FAIL  %s (%s)\n", title, r.Duration.Round(time.Millisecond))
+						fmt.Fprintf(out, "        %s\n", strings.ReplaceAll(r.Failure, "\n", "\n        "))
+					default:
+						passed++
+						fmt.Fprintf(out, "  PASS  %s (%s)\n", title, r.Duration.Round(time.Millisecond))
+					}
+				}
+			}
+			fmt.Fprintf(out, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+
+			if junitPath != "" {
+				f, err := os.Create(junitPath)
+				if err != nil {
+					return err
+				}
+				err = runtime.WriteJUnit(f, files)
+				if closeErr := f.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d tests failed", failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&junitPath, "junit", "", "also write results as JUnit XML to this path")
+	return cmd
+}
+
+func formatArg(a runtime.ArgDoc) string {
+	argType := a.Type
+	if argType == "" {
+		argType = "string"
+	}
+	required := ""
+	if a.Required {
+		required = "required"
+	}
+	var extra []string
+	if a.Default != nil {
+		extra = append(extra, fmt.Sprintf("default: %v", a.Default))
+	}
+	if len(a.Enum) > 0 {
+		values := make([]string, 0, len(a.Enum))
+		for _, v := range a.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		extra = append(extra, "one of: "+strings.Join(values, "|"))
+	}
+	if a.Example != nil {
+		extra = append(extra, fmt.Sprintf("example: %v", a.Example))
+	}
+	desc := a.Desc
+	if len(extra) > 0 {
+		desc = strings.TrimSpace(desc + " (" + strings.Join(extra, "; ") + ")")
+	}
+	return fmt.Sprintf("  %s\t%s\t%s\t%s", a.Name, argType, required, desc)
+}
+
 func newProfileCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "profile",
@@ -479,6 +887,296 @@ func newSecretCmd() *cobra.Command {
 	return cmd
 }
 
+func newNetworkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "network",
+		Short: "manage proxy and TLS settings for a profile",
+		Long: "Network settings apply to every request of a provider profile:\n" +
+			"  proxy            proxy URL, overriding HTTP(S)_PROXY, or \"direct\"\n" +
+			"  caCerts          comma-separated PEM files trusted besides the system roots\n" +
+			"  clientCert       PEM client certificate for mutual TLS\n" +
+			"  clientKey        PEM key file for clientCert\n" +
+			"  clientKeySecret  secret holding the PEM key instead of clientKey\n" +
+			"  tlsMinVersion    1.0, 1.1, 1.2 or 1.3",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <provider> <name> <value>",
+		Short: "set a network setting",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			if err := config.EnsureLayout(base); err != nil {
+				return err
+			}
+			path := config.ProviderProfilesPath(base, args[0])
+			profiles, err := config.LoadProfiles(path)
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			if err := config.UpsertNetwork(profiles, resolved, args[1], args[2]); err != nil {
+				return err
+			}
+			return config.SaveProfiles(path, profiles)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unset <provider> <name>",
+		Short: "remove a network setting",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			if err := config.EnsureLayout(base); err != nil {
+				return err
+			}
+			path := config.ProviderProfilesPath(base, args[0])
+			profiles, err := config.LoadProfiles(path)
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			if err := config.RemoveNetwork(profiles, resolved, args[1]); err != nil {
+				return err
+			}
+			return config.SaveProfiles(path, profiles)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <provider>",
+		Short: "list network settings",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			if err := config.EnsureLayout(base); err != nil {
+				return err
+			}
+			path := config.ProviderProfilesPath(base, args[0])
+			profiles, err := config.LoadProfiles(path)
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			network := profiles.Profiles[resolved].Network
+			if network == nil {
+				return nil
+			}
+			for _, key := range request.NetworkKeys {
+				if value := network.Get(key); value != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s=%s\n", key, value)
+				}
+			}
+			return nil
+		},
+	})
+	return cmd
+}
+
+func newAuthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "log in to providers that use OAuth",
+	}
+	var device, noBrowser bool
+	login := &cobra.Command{
+		Use:   "login <provider>",
+		Short: "run the provider's OAuth flow and store the token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			script, err := os.ReadFile(config.ProviderPath(base, args[0]))
+			if err != nil {
+				return fmt.Errorf("provider not found: %s", args[0])
+			}
+			cfg, err := runtime.OAuthConfig(script, base)
+			if err != nil {
+				return err
+			}
+			if cfg == nil {
+				return fmt.Errorf("provider %s does not declare oauth", args[0])
+			}
+			path := config.ProviderProfilesPath(base, args[0])
+			profiles, err := config.LoadProfiles(path)
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			prof := profiles.Profiles[resolved]
+			if cfg.Transport, err = runtime.NetworkTransport(args[0], resolved, prof.Network); err != nil {
+				return err
+			}
+			cfg.Timeout = timeout
+			if err := cfg.LoadClient(args[0], resolved); err != nil {
+				return err
+			}
+
+			flow := cfg.Flow
+			if device {
+				if cfg.DeviceURL == "" {
+					return fmt.Errorf("provider %s does not support the device flow", args[0])
+				}
+				flow = oauth.FlowDeviceCode
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), 10*time.Minute)
+			defer cancel()
+			var token *oauth.Token
+			switch flow {
+			case oauth.FlowAuthorizationCode:
+				open := openBrowser
+				if noBrowser {
+					open = nil
+				}
+				token, err = cfg.AuthorizationCode(ctx, open, cmd.ErrOrStderr())
+			case oauth.FlowDeviceCode:
+				token, err = cfg.DeviceCode(ctx, cmd.ErrOrStderr())
+			default:
+				token, err = cfg.ClientCredentials()
+			}
+			if err != nil {
+				return err
+			}
+			if err := oauth.SaveToken(args[0], resolved, token); err != nil {
+				return err
+			}
+			config.UpsertSecret(profiles, resolved, oauth.TokenSecret)
+			if err := config.SaveProfiles(path, profiles); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "logged in to %s (profile %s)\n", args[0], resolved)
+			return nil
+		},
+	}
+	login.Flags().BoolVar(&device, "device", false, "use the device code flow")
+	login.Flags().BoolVar(&noBrowser, "no-browser", false, "print the login URL instead of opening a browser")
+	cmd.AddCommand(login)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "logout <provider>",
+		Short: "remove the stored OAuth token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			path := config.ProviderProfilesPath(base, args[0])
+			profiles, err := config.LoadProfiles(path)
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			if err := oauth.DeleteToken(args[0], resolved); err != nil {
+				return err
+			}
+			config.RemoveSecret(profiles, resolved, oauth.TokenSecret)
+			return config.SaveProfiles(path, profiles)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status <provider>",
+		Short: "show whether a profile is logged in",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			profiles, err := config.LoadProfiles(config.ProviderProfilesPath(base, args[0]))
+			if err != nil {
+				return err
+			}
+			resolved, err := config.ResolveProfile(profiles, profile)
+			if err != nil {
+				return err
+			}
+			token, err := oauth.LoadToken(args[0], resolved)
+			if errors.Is(err, oauth.ErrLoginRequired) {
+				fmt.Fprintln(cmd.OutOrStdout(), "not logged in")
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			switch {
+			case token.Expiry.IsZero():
+				fmt.Fprintln(cmd.OutOrStdout(), "logged in")
+			case token.Valid():
+				fmt.Fprintf(cmd.OutOrStdout(), "logged in, token expires in %s\n", time.Until(token.Expiry).Round(time.Second))
+			case token.RefreshToken != "":
+				fmt.Fprintln(cmd.OutOrStdout(), "logged in, token expired and will be refreshed")
+			default:
+				fmt.Fprintln(cmd.OutOrStdout(), "token expired: run api auth login "+args[0])
+			}
+			if token.Scope != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "scope: %s\n", token.Scope)
+			}
+			return nil
+		},
+	})
+	return cmd
+}
+
+func openBrowser(target string) error {
+	switch goruntime.GOOS {
+	case "darwin":
+		return exec.Command("open", target).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
+}
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "manage cached responses",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "clear [provider]",
+		Short: "remove cached responses for one or all providers",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			base, err := config.BaseDir(configDir)
+			if err != nil {
+				return err
+			}
+			dir := filepath.Join(base, config.CacheDirName)
+			if len(args) == 1 {
+				dir = config.CacheDir(base, args[0])
+			}
+			return os.RemoveAll(dir)
+		},
+	})
+	return cmd
+}
+
 func parseProviderArgs(args []string) (string, string, []string) {
 	providerArg := args[0]
 	command := "default"
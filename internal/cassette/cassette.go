@@ -0,0 +1,213 @@
+// Package cassette stores recorded HTTP interactions in a JSON file and
+// finds them again for replay.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Match rules. HeaderRulePrefix followed by a header name compares just
+// that header.
+const (
+	MatchMethod      = "method"
+	MatchURL         = "url"
+	MatchPath        = "path"
+	MatchQuery       = "query"
+	MatchBody        = "body"
+	MatchHeaders     = "headers"
+	HeaderRulePrefix = "header:"
+)
+
+// DefaultMatch is used when no rules are given.
+var DefaultMatch = []string{MatchMethod, MatchURL}
+
+// Cassette is a list of interactions backed by a file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+
+	path string
+	used []bool
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a request as it was sent, with secrets scrubbed.
+type Request struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// Response bodies that are not valid UTF-8 are stored base64 encoded, with
+// Encoding set to "base64".
+type Response struct {
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+	Encoding string            `json:"encoding,omitempty"`
+}
+
+// New returns an empty cassette that Add writes to path.
+func New(path string) *Cassette {
+	return &Cassette{Interactions: []Interaction{}, path: path}
+}
+
+// Load reads the cassette at path.
+func Load(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cassette{path: path}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+// Add appends an interaction and saves the cassette, so a run that fails
+// halfway keeps what it recorded.
+func (c *Cassette) Add(i Interaction) error {
+	c.Interactions = append(c.Interactions, i)
+	c.used = append(c.used, true)
+	return c.save()
+}
+
+func (c *Cassette) save() error {
+	// Keep <name> placeholders readable instead of \u003c-escaped.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Find returns the first unused interaction matching req under rules,
+// falling back to a used one so repeated requests replay too.
+func (c *Cassette) Find(req Request, rules []string) (*Interaction, error) {
+	if len(rules) == 0 {
+		rules = DefaultMatch
+	}
+	found := -1
+	for i := range c.Interactions {
+		ok, err := matches(c.Interactions[i].Request, req, rules)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if !c.used[i] {
+			found = i
+			break
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("cassette %s has no interaction matching %s %s (by %s)", c.path, req.Method, req.URL, strings.Join(rules, ", "))
+	}
+	c.used[found] = true
+	return &c.Interactions[found], nil
+}
+
+// ValidateMatch checks match rules.
+func ValidateMatch(rules []string) error {
+	for _, rule := range rules {
+		switch rule {
+		case MatchMethod, MatchURL, MatchPath, MatchQuery, MatchBody, MatchHeaders:
+		default:
+			if !strings.HasPrefix(rule, HeaderRulePrefix) || rule == HeaderRulePrefix {
+				return fmt.Errorf("unknown match rule %q (want method, url, path, query, body, headers or header:NAME)", rule)
+			}
+		}
+	}
+	return nil
+}
+
+func matches(recorded, req Request, rules []string) (bool, error) {
+	for _, rule := range rules {
+		var ok bool
+		switch rule {
+		case MatchMethod:
+			ok = strings.EqualFold(recorded.Method, req.Method)
+		case MatchURL:
+			ok = recorded.URL == req.URL
+		case MatchPath, MatchQuery:
+			a, errA := url.Parse(recorded.URL)
+			b, errB := url.Parse(req.URL)
+			if errA != nil || errB != nil {
+				return false, errors.Join(errA, errB)
+			}
+			if rule == MatchPath {
+				ok = a.Scheme == b.Scheme && a.Host == b.Host && a.Path == b.Path
+			} else {
+				ok = reflect.DeepEqual(a.Query(), b.Query())
+			}
+		case MatchBody:
+			ok = sameBody(recorded.Body, req.Body)
+		case MatchHeaders:
+			ok = len(recorded.Headers) == len(req.Headers)
+			for name, value := range recorded.Headers {
+				ok = ok && header(req.Headers, name) == value
+			}
+		default:
+			name := strings.TrimPrefix(rule, HeaderRulePrefix)
+			ok = header(recorded.Headers, name) == header(req.Headers, name)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// sameBody compares JSON bodies by value, so key order does not matter.
+func sameBody(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb any
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+func header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
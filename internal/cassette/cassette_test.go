@@ -0,0 +1,67 @@
+package cassette
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "orders.json")
+	c := New(path)
+	for _, i := range []Interaction{
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=1&limit=2"}, Response{Status: 200, Body: "page 1"}},
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=1&limit=2"}, Response{Status: 200, Body: "page 1 again"}},
+		{Request{Method: "POST", URL: "https://api.example.com/orders", Headers: map[string]string{"Content-Type": "application/json"}, Body: `{"qty":1,"symbol":"AAPL"}`}, Response{Status: 201, Body: "created"}},
+	} {
+		if err := c.Add(i); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+
+	c, err := Load(path)
+	if err != nil || len(c.Interactions) != 3 {
+		t.Fatalf("Load: %v, %+v", err, c)
+	}
+	for _, tc := range []struct {
+		req   Request
+		rules []string
+		want  string
+	}{
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=1&limit=2"}, nil, "page 1"},
+		{Request{Method: "get", URL: "https://api.example.com/orders?page=1&limit=2"}, nil, "page 1 again"},
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=1&limit=2"}, nil, "page 1"},
+		{Request{Method: "GET", URL: "https://api.example.com/orders?limit=2&page=1"}, []string{"method", "path", "query"}, "page 1"},
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=9"}, []string{"method", "path"}, "page 1"},
+		{Request{Method: "POST", URL: "https://api.example.com/orders", Body: `{"symbol": "AAPL", "qty": 1}`}, []string{"method", "body"}, "created"},
+		{Request{Method: "POST", URL: "https://api.example.com/orders", Headers: map[string]string{"content-type": "application/json"}}, []string{"header:Content-Type"}, "created"},
+	} {
+		got, err := c.Find(tc.req, tc.rules)
+		if err != nil {
+			t.Fatalf("%+v: Find error: %v", tc.req, err)
+		}
+		if got.Response.Body != tc.want {
+			t.Fatalf("%+v by %v: expected %q, got %q", tc.req, tc.rules, tc.want, got.Response.Body)
+		}
+	}
+	for _, tc := range []struct {
+		req   Request
+		rules []string
+	}{
+		{Request{Method: "GET", URL: "https://api.example.com/orders?page=2&limit=2"}, nil},
+		{Request{Method: "POST", URL: "https://api.example.com/orders", Body: `{"qty":2,"symbol":"AAPL"}`}, []string{"method", "body"}},
+		{Request{Method: "POST", URL: "https://api.example.com/orders"}, []string{"method", "headers"}},
+	} {
+		if _, err := c.Find(tc.req, tc.rules); err == nil {
+			t.Fatalf("%+v by %v: expected no match", tc.req, tc.rules)
+		}
+	}
+
+	if err := ValidateMatch([]string{"method", "header:X-Api-Key"}); err != nil {
+		t.Fatalf("ValidateMatch error: %v", err)
+	}
+	for _, rules := range [][]string{{"verb"}, {"header:"}} {
+		if err := ValidateMatch(rules); err == nil {
+			t.Fatalf("%v: expected error", rules)
+		}
+	}
+}
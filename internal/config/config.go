@@ -9,6 +9,9 @@ import (
 const (
 	ProvidersDirName = "providers"
 	ProfilesDirName  = "profiles"
+	LibDirName       = "lib"
+	RateLimitDirName = "ratelimit"
+	CacheDirName     = "cache"
 )
 
 func BaseDir(override string) (string, error) {
@@ -35,6 +38,9 @@ func EnsureLayout(base string) error {
 	if err := os.MkdirAll(filepath.Join(base, ProfilesDirName), 0o755); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(filepath.Join(base, LibDirName), 0o755); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -46,6 +52,14 @@ func ProfilesDir(base string) string {
 	return filepath.Join(base, ProfilesDirName)
 }
 
+func LibDir(base string) string {
+	return filepath.Join(base, LibDirName)
+}
+
+func LibPath(base, name string) string {
+	return filepath.Join(LibDir(base), filepath.FromSlash(name)+".js")
+}
+
 func ProviderPath(base, provider string) string {
 	return filepath.Join(ProvidersDir(base), provider+".js")
 }
@@ -53,3 +67,11 @@ func ProviderPath(base, provider string) string {
 func ProviderProfilesPath(base, provider string) string {
 	return filepath.Join(ProfilesDir(base), provider+".json")
 }
+
+func RateLimitPath(base, provider, profile string) string {
+	return filepath.Join(base, RateLimitDirName, provider+"."+profile+".json")
+}
+
+func CacheDir(base, provider string) string {
+	return filepath.Join(base, CacheDirName, provider)
+}
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+
+	"github.com/patrickjm/api-cli/internal/request"
 )
 
 const DefaultProfile = "default"
 
 type Profile struct {
-	Secrets []string          `json:"secrets"`
-	Env     map[string]string `json:"env,omitempty"`
+	Secrets []string             `json:"secrets"`
+	Env     map[string]string    `json:"env,omitempty"`
+	Retry   *request.RetryPolicy `json:"retry,omitempty"`
+	Network *request.Network     `json:"network,omitempty"`
 }
 
 type Profiles struct {
@@ -154,3 +158,30 @@ func RemoveEnv(p *Profiles, profile, key string) {
 	delete(prof.Env, key)
 	p.Profiles[profile] = prof
 }
+
+func UpsertNetwork(p *Profiles, profile, key, value string) error {
+	if p.Profiles == nil {
+		p.Profiles = map[string]Profile{}
+	}
+	prof := p.Profiles[profile]
+	network := request.Network{}
+	if prof.Network != nil {
+		network = *prof.Network
+	}
+	if err := network.Set(key, value); err != nil {
+		return err
+	}
+	prof.Network = &network
+	if network.IsZero() {
+		prof.Network = nil
+	}
+	p.Profiles[profile] = prof
+	return nil
+}
+
+func RemoveNetwork(p *Profiles, profile, key string) error {
+	if p.Profiles[profile].Network == nil {
+		return nil
+	}
+	return UpsertNetwork(p, profile, key, "")
+}
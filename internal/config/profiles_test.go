@@ -43,3 +43,26 @@ func TestProfilesEnv(t *testing.T) {
 		t.Fatalf("env value not removed")
 	}
 }
+
+func TestProfilesNetwork(t *testing.T) {
+	profiles := &Profiles{Default: DefaultProfile, Profiles: map[string]Profile{DefaultProfile: {}}}
+	if err := UpsertNetwork(profiles, DefaultProfile, "caCerts", "a.pem, b.pem"); err != nil {
+		t.Fatalf("UpsertNetwork error: %v", err)
+	}
+	network := profiles.Profiles[DefaultProfile].Network
+	if network == nil || len(network.CACerts) != 2 || network.CACerts[1] != "b.pem" {
+		t.Fatalf("network value not set: %+v", network)
+	}
+	if err := UpsertNetwork(profiles, DefaultProfile, "tlsMinVersion", "1.4"); err == nil {
+		t.Fatalf("expected invalid TLS version error")
+	}
+	if err := UpsertNetwork(profiles, DefaultProfile, "bogus", "x"); err == nil {
+		t.Fatalf("expected unknown setting error")
+	}
+	if err := RemoveNetwork(profiles, DefaultProfile, "caCerts"); err != nil {
+		t.Fatalf("RemoveNetwork error: %v", err)
+	}
+	if profiles.Profiles[DefaultProfile].Network != nil {
+		t.Fatalf("expected empty network settings to be dropped")
+	}
+}
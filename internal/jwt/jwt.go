@@ -0,0 +1,383 @@
+// Package jwt signs and verifies JSON Web Tokens with HMAC, RSA, RSA-PSS,
+// ECDSA and Ed25519 keys.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken     = errors.New("jwt: malformed token")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token is expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+)
+
+type algorithm struct {
+	hash crypto.Hash
+	kind string // hmac, rsa, pss, ecdsa or eddsa
+	size int    // ECDSA coordinate size in bytes
+}
+
+var algorithms = map[string]algorithm{
+	"HS256": {crypto.SHA256, "hmac", 0},
+	"HS384": {crypto.SHA384, "hmac", 0},
+	"HS512": {crypto.SHA512, "hmac", 0},
+	"RS256": {crypto.SHA256, "rsa", 0},
+	"RS384": {crypto.SHA384, "rsa", 0},
+	"RS512": {crypto.SHA512, "rsa", 0},
+	"PS256": {crypto.SHA256, "pss", 0},
+	"PS384": {crypto.SHA384, "pss", 0},
+	"PS512": {crypto.SHA512, "pss", 0},
+	"ES256": {crypto.SHA256, "ecdsa", 32},
+	"ES384": {crypto.SHA384, "ecdsa", 48},
+	"ES512": {crypto.SHA512, "ecdsa", 66},
+	"EdDSA": {0, "eddsa", 0},
+}
+
+// ParseKey reads a PEM private key, public key or certificate. Keys for
+// HS* algorithms are used as raw bytes.
+func ParseKey(data []byte, alg string) (any, error) {
+	if strings.HasPrefix(alg, "HS") {
+		return data, nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if alg == "" {
+			// Not PEM, so it can only be an HMAC secret.
+			return data, nil
+		}
+		return nil, errors.New("jwt: key is not PEM encoded")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("jwt: unsupported PEM block %q", block.Type)
+}
+
+// DefaultAlg picks the algorithm matching a key.
+func DefaultAlg(key any) string {
+	switch k := key.(type) {
+	case []byte:
+		return "HS256"
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RS256"
+	case *ecdsa.PrivateKey:
+		return ecdsaAlg(k.Curve)
+	case *ecdsa.PublicKey:
+		return ecdsaAlg(k.Curve)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "EdDSA"
+	}
+	return ""
+}
+
+func ecdsaAlg(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P384():
+		return "ES384"
+	case elliptic.P521():
+		return "ES512"
+	}
+	return "ES256"
+}
+
+// SignOptions set the header of a signed token. Alg defaults to
+// DefaultAlg of the key.
+type SignOptions struct {
+	Alg    string
+	KeyID  string
+	Header map[string]any
+}
+
+// Sign encodes claims and signs them with key.
+func Sign(claims map[string]any, key any, opts SignOptions) (string, error) {
+	alg := opts.Alg
+	if alg == "" {
+		alg = DefaultAlg(key)
+	}
+	spec, ok := algorithms[alg]
+	if !ok {
+		return "", fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+	header := map[string]any{}
+	for k, v := range opts.Header {
+		header[k] = v
+	}
+	header["alg"] = alg
+	header["typ"] = "JWT"
+	if opts.KeyID != "" {
+		header["kid"] = opts.KeyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+	sig, err := sign(spec, key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + encode(sig), nil
+}
+
+// VerifyOptions control token validation. Algs lists the accepted
+// algorithms and defaults to DefaultAlg of the key. Leeway allows for clock
+// skew in exp and nbf.
+type VerifyOptions struct {
+	Algs   []string
+	Leeway time.Duration
+	Now    time.Time
+}
+
+// Verify checks the signature and the exp and nbf claims, returning the
+// claims.
+func Verify(token string, key any, opts VerifyOptions) (map[string]any, error) {
+	header, claims, err := Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	alg, _ := header["alg"].(string)
+	allowed := opts.Algs
+	if len(allowed) == 0 {
+		allowed = []string{DefaultAlg(key)}
+	}
+	accepted := false
+	for _, a := range allowed {
+		accepted = accepted || a == alg
+	}
+	if !accepted {
+		return nil, fmt.Errorf("jwt: algorithm %q is not allowed", alg)
+	}
+	spec, ok := algorithms[alg]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", alg)
+	}
+	i := strings.LastIndex(token, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := verify(spec, key, []byte(token[:i]), sig); err != nil {
+		return nil, err
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if exp, ok := numericClaim(claims, "exp"); ok && !now.Before(exp.Add(opts.Leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(opts.Leeway).Before(nbf) {
+		return nil, ErrNotYetValid
+	}
+	return claims, nil
+}
+
+// Decode returns the header and claims of a token without verifying it.
+func Decode(token string) (header, claims map[string]any, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, ErrInvalidToken
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, nil, err
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, nil, err
+	}
+	return header, claims, nil
+}
+
+func decodeSegment(segment string, out *map[string]any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(out); err != nil || *out == nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	var secs float64
+	switch v := claims[name].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		secs = f
+	case float64:
+		secs = v
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), true
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	hasher := h.New()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
+
+func sign(spec algorithm, key any, data []byte) ([]byte, error) {
+	switch spec.kind {
+	case "hmac":
+		secret, ok := key.([]byte)
+		if !ok {
+			return nil, errors.New("jwt: HMAC algorithms need a shared secret")
+		}
+		mac := hmac.New(spec.hash.New, secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case "rsa", "pss":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt: RSA algorithms need an RSA private key")
+		}
+		if spec.kind == "pss" {
+			return rsa.SignPSS(rand.Reader, k, spec.hash, digest(spec.hash, data), &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, k, spec.hash, digest(spec.hash, data))
+	case "ecdsa":
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecdsaAlg(k.Curve) != algForSize(spec.size) {
+			return nil, errors.New("jwt: ECDSA algorithm does not match the key")
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest(spec.hash, data))
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 2*spec.size)
+		r.FillBytes(sig[:spec.size])
+		s.FillBytes(sig[spec.size:])
+		return sig, nil
+	case "eddsa":
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt: EdDSA needs an Ed25519 private key")
+		}
+		return ed25519.Sign(k, data), nil
+	}
+	return nil, errors.New("jwt: unsupported algorithm")
+}
+
+func verify(spec algorithm, key any, data, sig []byte) error {
+	key = publicKey(key)
+	switch spec.kind {
+	case "hmac":
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("jwt: HMAC algorithms need a shared secret")
+		}
+		mac := hmac.New(spec.hash.New, secret)
+		mac.Write(data)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "rsa", "pss":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("jwt: RSA algorithms need an RSA key")
+		}
+		var err error
+		if spec.kind == "pss" {
+			err = rsa.VerifyPSS(k, spec.hash, digest(spec.hash, data), sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		} else {
+			err = rsa.VerifyPKCS1v15(k, spec.hash, digest(spec.hash, data), sig)
+		}
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "ecdsa":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 2*spec.size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:spec.size])
+		s := new(big.Int).SetBytes(sig[spec.size:])
+		if !ecdsa.Verify(k, digest(spec.hash, data), r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case "eddsa":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, data, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return errors.New("jwt: unsupported algorithm")
+}
+
+// publicKey returns the public half of a private key, so tokens can be
+// verified with the key that signed them.
+func publicKey(key any) any {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return key
+}
+
+func algForSize(size int) string {
+	switch size {
+	case 48:
+		return "ES384"
+	case 66:
+		return "ES512"
+	}
+	return "ES256"
+}
@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wait sleeps between device flow polls; tests shorten it.
+var wait = func(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// AuthorizationCode runs the authorization code flow with PKCE. It serves
+// the redirect on a loopback port, prints the authorization URL to out and
+// passes it to open, then exchanges the returned code for a token.
+func (c *Config) AuthorizationCode(ctx context.Context, open func(string) error, out io.Writer) (*Token, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(c.RedirectPort)))
+	if err != nil {
+		return nil, fmt.Errorf("oauth: listen for redirect: %w", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	state := randomString(16)
+	verifier := randomString(32)
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := url.Parse(c.AuthURL)
+	if err != nil {
+		return nil, fmt.Errorf("oauth: invalid authUrl: %w", err)
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", c.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if len(c.Scopes) > 0 {
+		q.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for k, v := range c.Params {
+		q.Set(k, v)
+	}
+	authURL.RawQuery = q.Encode()
+
+	type callback struct {
+		code string
+		err  error
+	}
+	done := make(chan callback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		params := r.URL.Query()
+		var result callback
+		switch {
+		case params.Get("state") != state:
+			result.err = errors.New("oauth: redirect state does not match")
+		case params.Get("error") != "":
+			result.err = &Error{Code: params.Get("error"), Description: params.Get("error_description")}
+		case params.Get("code") == "":
+			result.err = errors.New("oauth: redirect has no code")
+		default:
+			result.code = params.Get("code")
+		}
+		message := "Logged in. You can close this window."
+		if result.err != nil {
+			message = "Login failed: " + result.err.Error()
+		}
+		fmt.Fprintf(w, "<!doctype html><p>%s</p>", html.EscapeString(message))
+		select {
+		case done <- result:
+		default:
+		}
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	fmt.Fprintf(out, "Open this URL to log in:\n\n  %s\n\n", authURL)
+	if open != nil {
+		_ = open(authURL.String())
+	}
+
+	var result callback
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("oauth: waiting for login: %w", ctx.Err())
+	case result = <-done:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	return c.exchange(url.Values{
+		"grant_type":    {FlowAuthorizationCode},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+type deviceResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURL         string `json:"verification_url"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceCode runs the device authorization flow, printing the code for the
+// user to enter and polling until they approve it.
+func (c *Config) DeviceCode(ctx context.Context, out io.Writer) (*Token, error) {
+	form := url.Values{}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	var device deviceResponse
+	if err := c.post(c.DeviceURL, form, &device); err != nil {
+		return nil, err
+	}
+	if device.DeviceCode == "" {
+		return nil, errors.New("oauth: device response has no device_code")
+	}
+	verify := device.VerificationURI
+	if verify == "" {
+		verify = device.VerificationURL
+	}
+	fmt.Fprintf(out, "Go to %s and enter the code %s\n", verify, device.UserCode)
+	if device.VerificationURIComplete != "" {
+		fmt.Fprintf(out, "or open %s\n", device.VerificationURIComplete)
+	}
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if device.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(device.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	for {
+		if err := wait(ctx, interval); err != nil {
+			return nil, fmt.Errorf("oauth: waiting for device approval: %w", err)
+		}
+		tok, err := c.exchange(url.Values{
+			"grant_type":  {deviceGrantType},
+			"device_code": {device.DeviceCode},
+		})
+		var oauthErr *Error
+		if !errors.As(err, &oauthErr) {
+			return tok, err
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,340 @@
+// Package oauth implements the OAuth2 flows providers declare in their
+// oauth metadata. Tokens are kept in the secret store under TokenSecret and
+// refreshed before they expire.
+package oauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickjm/api-cli/internal/request"
+	"github.com/patrickjm/api-cli/internal/secret"
+)
+
+// Grant types, used as the flow name in metadata.
+const (
+	FlowAuthorizationCode = "authorization_code"
+	FlowDeviceCode        = "device_code"
+	FlowClientCredentials = "client_credentials"
+)
+
+const (
+	// TokenSecret is the secret holding the token as JSON.
+	TokenSecret = "oauth_token"
+	// ClientIDSecret and ClientSecretSecret hold the client credentials when
+	// the metadata does not include them.
+	ClientIDSecret     = "client_id"
+	ClientSecretSecret = "client_secret"
+
+	deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	expiryMargin    = 30 * time.Second
+)
+
+// ErrLoginRequired is returned when there is no usable token and the flow
+// needs the user.
+var ErrLoginRequired = errors.New("not logged in")
+
+// Config is a provider's oauth metadata:
+//
+//	oauth: {
+//	  flow: "authorization_code",
+//	  authUrl: "https://example.com/oauth/authorize",
+//	  tokenUrl: "https://example.com/oauth/token",
+//	  deviceUrl: "https://example.com/oauth/device/code",
+//	  clientId: "abc123",
+//	  scopes: ["read", "write"],
+//	  hosts: ["api.example.com"],
+//	}
+type Config struct {
+	// Flow is authorization_code (PKCE with a loopback redirect),
+	// device_code or client_credentials. It defaults to the first one the
+	// URLs allow.
+	Flow      string `json:"flow,omitempty"`
+	AuthURL   string `json:"authUrl,omitempty"`
+	TokenURL  string `json:"tokenUrl"`
+	DeviceURL string `json:"deviceUrl,omitempty"`
+	// ClientID and ClientSecret fall back to the client_id and
+	// client_secret secrets.
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// Params are added to the authorization URL, e.g. audience or prompt.
+	Params map[string]string `json:"params,omitempty"`
+	// RedirectPort fixes the loopback port for apps that register an exact
+	// redirect URI; 0 picks a free one.
+	RedirectPort int `json:"redirectPort,omitempty"`
+	// AuthStyle "basic" sends client credentials in an Authorization header
+	// instead of the form body.
+	AuthStyle string `json:"authStyle,omitempty"`
+	// Hosts limits which request hosts get the token; empty means all.
+	Hosts []string `json:"hosts,omitempty"`
+
+	Transport http.RoundTripper `json:"-"`
+	Timeout   time.Duration     `json:"-"`
+}
+
+// Token is a stored access token.
+type Token struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType,omitempty"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+}
+
+// Error is an OAuth error response.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth: %s: %s", e.Code, e.Description)
+	}
+	return "oauth: " + e.Code
+}
+
+// Validate checks the metadata and fills in the default flow.
+func (c *Config) Validate() error {
+	if c.TokenURL == "" {
+		return errors.New("oauth needs tokenUrl")
+	}
+	if c.Flow == "" {
+		switch {
+		case c.AuthURL != "":
+			c.Flow = FlowAuthorizationCode
+		case c.DeviceURL != "":
+			c.Flow = FlowDeviceCode
+		default:
+			c.Flow = FlowClientCredentials
+		}
+	}
+	switch c.Flow {
+	case FlowAuthorizationCode:
+		if c.AuthURL == "" {
+			return errors.New("authorization_code flow needs authUrl")
+		}
+	case FlowDeviceCode:
+		if c.DeviceURL == "" {
+			return errors.New("device_code flow needs deviceUrl")
+		}
+	case FlowClientCredentials:
+	default:
+		return fmt.Errorf("unknown oauth flow %q", c.Flow)
+	}
+	return nil
+}
+
+// LoadClient fills in ClientID and ClientSecret from the profile's secrets
+// when the metadata leaves them out.
+func (c *Config) LoadClient(provider, profile string) error {
+	if c.ClientID == "" {
+		id, err := secret.Get(provider, profile, ClientIDSecret)
+		if err != nil {
+			return fmt.Errorf("oauth client id not found: set it with api secret set %s %s <id>", provider, ClientIDSecret)
+		}
+		c.ClientID = id
+	}
+	if c.ClientSecret == "" {
+		if s, err := secret.Get(provider, profile, ClientSecretSecret); err == nil {
+			c.ClientSecret = s
+		}
+	}
+	return nil
+}
+
+// Applies reports whether requests to rawURL should carry the token.
+func (c *Config) Applies(rawURL string) bool {
+	if len(c.Hosts) == 0 {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, host := range c.Hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Token returns a valid token for the profile, refreshing or, for client
+// credentials, requesting a new one as needed.
+func (c *Config) Token(provider, profile string) (*Token, error) {
+	tok, err := LoadToken(provider, profile)
+	if err != nil && !errors.Is(err, ErrLoginRequired) {
+		return nil, err
+	}
+	if tok != nil && tok.Valid() {
+		return tok, nil
+	}
+	switch {
+	case tok != nil && tok.RefreshToken != "":
+		tok, err = c.Refresh(tok.RefreshToken)
+	case c.Flow == FlowClientCredentials:
+		tok, err = c.ClientCredentials()
+	default:
+		return nil, fmt.Errorf("%w: run api auth login %s", ErrLoginRequired, provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveToken(provider, profile, tok); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// ClientCredentials requests a token with the client_credentials grant.
+func (c *Config) ClientCredentials() (*Token, error) {
+	form := url.Values{"grant_type": {FlowClientCredentials}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	return c.exchange(form)
+}
+
+// Refresh trades a refresh token for a new token, keeping the old refresh
+// token when the server does not rotate it.
+func (c *Config) Refresh(refreshToken string) (*Token, error) {
+	tok, err := c.exchange(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = refreshToken
+	}
+	return tok, nil
+}
+
+// Valid reports whether the token can be used without refreshing.
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(expiryMargin).Before(t.Expiry)
+}
+
+// Header is the Authorization header value for the token.
+func (t *Token) Header() string {
+	kind := t.TokenType
+	if kind == "" || strings.EqualFold(kind, "bearer") {
+		kind = "Bearer"
+	}
+	return kind + " " + t.AccessToken
+}
+
+func (c *Config) exchange(form url.Values) (*Token, error) {
+	var result tokenResponse
+	if err := c.post(c.TokenURL, form, &result); err != nil {
+		return nil, err
+	}
+	return result.token()
+}
+
+// post sends a form with the client credentials and decodes the JSON reply,
+// turning OAuth error responses into *Error.
+func (c *Config) post(endpoint string, form url.Values, out any) error {
+	headers := map[string]string{"Accept": "application/json"}
+	if c.AuthStyle == "basic" && c.ClientSecret != "" {
+		creds := url.QueryEscape(c.ClientID) + ":" + url.QueryEscape(c.ClientSecret)
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+	} else {
+		form.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			form.Set("client_secret", c.ClientSecret)
+		}
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	resp, err := request.Do(request.Spec{
+		Method:    http.MethodPost,
+		URL:       endpoint,
+		Headers:   headers,
+		Body:      form,
+		Transport: c.Transport,
+	}, timeout)
+	if err != nil {
+		return err
+	}
+	var oauthErr struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(resp.Body, &oauthErr) == nil && oauthErr.Error != "" {
+		return &Error{Code: oauthErr.Error, Description: oauthErr.Description}
+	}
+	if resp.Status >= 400 {
+		return fmt.Errorf("oauth: %s returned status %d: %s", endpoint, resp.Status, resp.Body)
+	}
+	if err := json.Unmarshal(resp.Body, out); err != nil {
+		return fmt.Errorf("oauth: invalid response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+type tokenResponse struct {
+	AccessToken  string          `json:"access_token"`
+	TokenType    string          `json:"token_type"`
+	RefreshToken string          `json:"refresh_token"`
+	ExpiresIn    json.RawMessage `json:"expires_in"`
+	Scope        string          `json:"scope"`
+}
+
+func (r tokenResponse) token() (*Token, error) {
+	if r.AccessToken == "" {
+		return nil, errors.New("oauth: token response has no access_token")
+	}
+	tok := &Token{
+		AccessToken:  r.AccessToken,
+		TokenType:    r.TokenType,
+		RefreshToken: r.RefreshToken,
+		Scope:        r.Scope,
+	}
+	// Some servers send expires_in as a string.
+	if secs, err := strconv.Atoi(strings.Trim(string(r.ExpiresIn), `"`)); err == nil && secs > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return tok, nil
+}
+
+// LoadToken reads the stored token, returning ErrLoginRequired when there
+// is none.
+func LoadToken(provider, profile string) (*Token, error) {
+	raw, err := secret.Get(provider, profile, TokenSecret)
+	if err != nil || raw == "" {
+		return nil, ErrLoginRequired
+	}
+	var tok Token
+	if err := json.Unmarshal([]byte(raw), &tok); err != nil {
+		return nil, fmt.Errorf("stored oauth token is invalid: %w", err)
+	}
+	return &tok, nil
+}
+
+func SaveToken(provider, profile string, tok *Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return secret.Set(provider, profile, TokenSecret, string(b))
+}
+
+func DeleteToken(provider, profile string) error {
+	return secret.Delete(provider, profile, TokenSecret)
+}
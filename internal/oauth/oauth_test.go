@@ -0,0 +1,162 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/patrickjm/api-cli/internal/secret"
+)
+
+func tokenServer(t *testing.T, handle func(form url.Values) (int, any)) (*httptest.Server, *[]url.Values) {
+	t.Helper()
+	var forms []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("ParseForm error: %v", err)
+		}
+		forms = append(forms, r.PostForm)
+		status, body := handle(r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &forms
+}
+
+func TestTokenClientCredentialsAndRefresh(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	server, forms := tokenServer(t, func(form url.Values) (int, any) {
+		switch form.Get("grant_type") {
+		case "client_credentials":
+			return http.StatusOK, map[string]any{"access_token": "cc", "token_type": "bearer", "expires_in": 3600}
+		case "refresh_token":
+			return http.StatusOK, map[string]any{"access_token": "fresh", "expires_in": "3600"}
+		}
+		return http.StatusBadRequest, map[string]any{"error": "unsupported_grant_type"}
+	})
+
+	cfg := &Config{TokenURL: server.URL, ClientID: "id", ClientSecret: "shh", Scopes: []string{"a", "b"}}
+	if err := cfg.Validate(); err != nil || cfg.Flow != FlowClientCredentials {
+		t.Fatalf("expected client_credentials default, got %q %v", cfg.Flow, err)
+	}
+	tok, err := cfg.Token("p", "default")
+	if err != nil {
+		t.Fatalf("Token error: %v", err)
+	}
+	if tok.Header() != "Bearer cc" || (*forms)[0].Get("scope") != "a b" || (*forms)[0].Get("client_secret") != "shh" {
+		t.Fatalf("unexpected token %+v from form %v", tok, (*forms)[0])
+	}
+	if _, err := cfg.Token("p", "default"); err != nil || len(*forms) != 1 {
+		t.Fatalf("expected stored token to be reused, got %d requests (%v)", len(*forms), err)
+	}
+
+	expired := &Token{AccessToken: "old", RefreshToken: "r1", Expiry: time.Now().Add(-time.Minute)}
+	if err := SaveToken("p", "default", expired); err != nil {
+		t.Fatalf("SaveToken error: %v", err)
+	}
+	tok, err = cfg.Token("p", "default")
+	if err != nil {
+		t.Fatalf("Token error: %v", err)
+	}
+	if tok.AccessToken != "fresh" || tok.RefreshToken != "r1" || !tok.Valid() {
+		t.Fatalf("expected refreshed token keeping the refresh token, got %+v", tok)
+	}
+	stored, _ := LoadToken("p", "default")
+	if stored.AccessToken != "fresh" {
+		t.Fatalf("expected refreshed token to be stored, got %+v", stored)
+	}
+
+	code := &Config{Flow: FlowAuthorizationCode, AuthURL: server.URL, TokenURL: server.URL, ClientID: "id"}
+	if _, err := code.Token("p", "other"); err == nil {
+		t.Fatalf("expected login required")
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	var verifier string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("audience") != "api" {
+			t.Errorf("unexpected authorization params %v", q)
+		}
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {"c0de"}, "state": {q.Get("state")}}.Encode()
+		verifier = q.Get("code_challenge")
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "c0de" || base64.RawURLEncoding.EncodeToString(sum[:]) != verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token":"user","refresh_token":"r","expires_in":60}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := &Config{AuthURL: server.URL + "/authorize", TokenURL: server.URL + "/token", ClientID: "id", Params: map[string]string{"audience": "api"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+	browse := func(target string) error {
+		go func() {
+			resp, err := http.Get(target)
+			if err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tok, err := cfg.AuthorizationCode(ctx, browse, io.Discard)
+	if err != nil {
+		t.Fatalf("AuthorizationCode error: %v", err)
+	}
+	if tok.AccessToken != "user" || tok.RefreshToken != "r" {
+		t.Fatalf("unexpected token %+v", tok)
+	}
+}
+
+func TestDeviceCodeFlow(t *testing.T) {
+	orig := wait
+	wait = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+	defer func() { wait = orig }()
+
+	polls := 0
+	server, _ := tokenServer(t, func(form url.Values) (int, any) {
+		if form.Get("grant_type") == "" {
+			return http.StatusOK, map[string]any{"device_code": "dev", "user_code": "ABCD", "verification_uri": "https://example.com/device", "interval": 1}
+		}
+		polls++
+		if polls < 3 {
+			return http.StatusBadRequest, map[string]any{"error": "authorization_pending"}
+		}
+		return http.StatusOK, map[string]any{"access_token": "device"}
+	})
+	cfg := &Config{DeviceURL: server.URL, TokenURL: server.URL, ClientID: "id"}
+	if err := cfg.Validate(); err != nil || cfg.Flow != FlowDeviceCode {
+		t.Fatalf("expected device_code default, got %q %v", cfg.Flow, err)
+	}
+	tok, err := cfg.DeviceCode(context.Background(), io.Discard)
+	if err != nil {
+		t.Fatalf("DeviceCode error: %v", err)
+	}
+	if tok.AccessToken != "device" || polls != 3 {
+		t.Fatalf("expected token after 3 polls, got %+v after %d", tok, polls)
+	}
+}
@@ -0,0 +1,415 @@
+// Package output renders JSON results as tables, CSV, TSV, YAML, NDJSON or
+// indented JSON. Arrays of objects become one row per item, with nested
+// objects flattened into dot-separated columns.
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats.
+const (
+	Table  = "table"
+	CSV    = "csv"
+	TSV    = "tsv"
+	YAML   = "yaml"
+	NDJSON = "ndjson"
+	JSON   = "json"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{Table, CSV, TSV, YAML, NDJSON, JSON}
+
+// Tabular reports whether format lays rows out in columns, where a
+// command's default columns apply.
+func Tabular(format string) bool {
+	return format == Table || format == CSV || format == TSV
+}
+
+// Render writes the JSON document data in the given format. columns are dot
+// paths to show, in order; without them tables show every flattened field
+// and the other formats show the whole value.
+func Render(w io.Writer, format string, data []byte, columns []string) error {
+	v, err := decode(data)
+	if err != nil {
+		return fmt.Errorf("result is not JSON: %w", err)
+	}
+	switch format {
+	case Table, CSV, TSV:
+		return writeRows(w, format, rows(v), columns)
+	case NDJSON:
+		for _, item := range rows(v) {
+			if len(columns) > 0 {
+				item = project(item, columns)
+			}
+			b, err := marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+				return err
+			}
+		}
+		return nil
+	case JSON, YAML:
+		if len(columns) > 0 {
+			projected := []any{}
+			for _, item := range rows(v) {
+				projected = append(projected, project(item, columns))
+			}
+			v = projected
+		}
+		if format == YAML {
+			var b strings.Builder
+			writeYAML(&b, v, 0)
+			_, err := io.WriteString(w, b.String())
+			return err
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return fmt.Errorf("unknown output format %q (want %s)", format, strings.Join(Formats, ", "))
+}
+
+// object is a JSON object that keeps its key order.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeValue(dec)
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &object{values: map[string]any{}}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := obj.values[key]; !dup {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = value
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		items := []any{}
+		for dec.More() {
+			item, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := dec.Token()
+		return items, err
+	}
+	return tok, nil
+}
+
+// rows returns the items of an array, or v itself as the only row.
+func rows(v any) []any {
+	switch v := v.(type) {
+	case []any:
+		return v
+	case nil:
+		return nil
+	}
+	return []any{v}
+}
+
+// row is an item flattened into dot-separated fields.
+type row struct {
+	keys  []string
+	cells map[string]any
+}
+
+func flatten(item any) row {
+	r := row{cells: map[string]any{}}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		if obj, ok := v.(*object); ok && len(obj.keys) > 0 {
+			for _, k := range obj.keys {
+				walk(prefix+k+".", obj.values[k])
+			}
+			return
+		}
+		key := strings.TrimSuffix(prefix, ".")
+		if key == "" {
+			key = "value"
+		}
+		if _, dup := r.cells[key]; !dup {
+			r.keys = append(r.keys, key)
+		}
+		r.cells[key] = v
+	}
+	walk("", item)
+	return r
+}
+
+// lookup follows a dot path through nested objects.
+func lookup(v any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(*object)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj.values[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// project keeps just the given columns of an item.
+func project(item any, columns []string) any {
+	out := &object{values: map[string]any{}}
+	for _, col := range columns {
+		value, ok := lookup(item, col)
+		if !ok && col == "value" {
+			value = item
+		}
+		out.keys = append(out.keys, col)
+		out.values[col] = value
+	}
+	return out
+}
+
+func writeRows(w io.Writer, format string, items []any, columns []string) error {
+	flat := make([]row, len(items))
+	for i, item := range items {
+		flat[i] = flatten(item)
+	}
+	if len(columns) == 0 {
+		seen := map[string]bool{}
+		for _, r := range flat {
+			for _, k := range r.keys {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	lines := [][]string{columns}
+	for i, r := range flat {
+		line := make([]string, len(columns))
+		for j, col := range columns {
+			value, ok := r.cells[col]
+			if !ok {
+				value, _ = lookup(items[i], col)
+			}
+			line[j] = cellText(value)
+		}
+		lines = append(lines, line)
+	}
+
+	if format == Table {
+		var buf bytes.Buffer
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		for _, line := range lines {
+			for j, cell := range line {
+				line[j] = strings.Map(func(r rune) rune {
+					if r == '\t' || r == '\n' || r == '\r' {
+						return ' '
+					}
+					return r
+				}, cell)
+			}
+			if _, err := fmt.Fprintln(tw, strings.Join(line, "\t")); err != nil {
+				return err
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		// Empty trailing cells still get padded.
+		var out strings.Builder
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			out.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+		_, err := io.WriteString(w, out.String())
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if format == TSV {
+		cw.Comma = '\t'
+	}
+	if err := cw.WriteAll(lines); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+func cellText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := marshal(v)
+	return string(b)
+}
+
+func writeYAML(b *strings.Builder, v any, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := v.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			b.WriteString(pad + "{}\n")
+			return
+		}
+		for _, k := range v.keys {
+			b.WriteString(pad + yamlString(k) + ":")
+			value := v.values[k]
+			switch {
+			case !yamlBlock(value):
+				b.WriteString(" " + yamlScalar(value) + "\n")
+			case isObject(value):
+				b.WriteString("\n")
+				writeYAML(b, value, indent+2)
+			default:
+				b.WriteString("\n")
+				writeYAML(b, value, indent)
+			}
+		}
+	case []any:
+		if len(v) == 0 {
+			b.WriteString(pad + "[]\n")
+			return
+		}
+		for _, item := range v {
+			switch {
+			case !yamlBlock(item):
+				b.WriteString(pad + "- " + yamlScalar(item) + "\n")
+			case isObject(item):
+				// The first key goes on the dash line.
+				var nested strings.Builder
+				writeYAML(&nested, item, indent+2)
+				b.WriteString(pad + "- " + strings.TrimPrefix(nested.String(), pad+"  "))
+			default:
+				b.WriteString(pad + "-\n")
+				writeYAML(b, item, indent+2)
+			}
+		}
+	default:
+		b.WriteString(pad + yamlScalar(v) + "\n")
+	}
+}
+
+func isObject(v any) bool {
+	_, ok := v.(*object)
+	return ok
+}
+
+// yamlBlock reports whether v is written on its own lines.
+func yamlBlock(v any) bool {
+	switch v := v.(type) {
+	case *object:
+		return len(v.keys) > 0
+	case []any:
+		return len(v) > 0
+	}
+	return false
+}
+
+func yamlScalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	case *object:
+		return "{}"
+	case []any:
+		return "[]"
+	}
+	return cellText(v)
+}
+
+var (
+	plainYAML    = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_ ./@-]*$`)
+	reservedYAML = regexp.MustCompile(`(?i)^(y|n|yes|no|on|off|true|false|null)$`)
+)
+
+// yamlString writes s unquoted when no YAML parser could read it as
+// anything else, and as a JSON string, which is valid YAML, otherwise.
+func yamlString(s string) string {
+	if plainYAML.MatchString(s) && !reservedYAML.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	b, _ := marshal(s)
+	return string(b)
+}
@@ -0,0 +1,97 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := []byte(`[
+  {"symbol": "AAPL", "qty": "10", "market_value": 1900.5, "meta": {"exchange": "NASDAQ", "tags": ["a", "b"]}},
+  {"symbol": "TSLA", "qty": "-3", "note": "short, \"hedge\"", "meta": {"exchange": "NASDAQ", "tags": []}}
+]`)
+	for _, tc := range []struct {
+		format  string
+		columns []string
+		want    string
+	}{
+		{Table, nil, `
+symbol  qty  market_value  meta.exchange  meta.tags  note
+AAPL    10   1900.5        NASDAQ         ["a","b"]
+TSLA    -3                 NASDAQ         []         short, "hedge"
+`},
+		{Table, []string{"symbol", "meta", "missing"}, `
+symbol  meta                                    missing
+AAPL    {"exchange":"NASDAQ","tags":["a","b"]}
+TSLA    {"exchange":"NASDAQ","tags":[]}
+`},
+		{CSV, []string{"symbol", "note"}, `
+symbol,note
+AAPL,
+TSLA,"short, ""hedge"""
+`},
+		{TSV, []string{"symbol", "qty"}, "\nsymbol\tqty\nAAPL\t10\nTSLA\t-3\n"},
+		{NDJSON, []string{"symbol", "meta.exchange"}, `
+{"symbol":"AAPL","meta.exchange":"NASDAQ"}
+{"symbol":"TSLA","meta.exchange":"NASDAQ"}
+`},
+		{JSON, []string{"qty"}, `
+[
+  {
+    "qty": "10"
+  },
+  {
+    "qty": "-3"
+  }
+]
+`},
+		{YAML, nil, `
+- symbol: AAPL
+  qty: "10"
+  market_value: 1900.5
+  meta:
+    exchange: NASDAQ
+    tags:
+    - a
+    - b
+- symbol: TSLA
+  qty: "-3"
+  note: "short, \"hedge\""
+  meta:
+    exchange: NASDAQ
+    tags: []
+`},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, tc.format, data, tc.columns); err != nil {
+			t.Fatalf("%s %v: %v", tc.format, tc.columns, err)
+		}
+		if want := strings.TrimPrefix(tc.want, "\n"); buf.String() != want {
+			t.Errorf("%s %v:\n%s\nwant:\n%s", tc.format, tc.columns, buf.String(), want)
+		}
+	}
+}
+
+func TestRenderScalars(t *testing.T) {
+	for _, tc := range []struct{ format, data, want string }{
+		{Table, `{"id": 1, "ok": true}`, "id  ok\n1   true\n"},
+		{Table, `["a", "b"]`, "value\na\nb\n"},
+		{Table, `[]`, ""},
+		{NDJSON, `{"b": 1, "a": 2}`, "{\"b\":1,\"a\":2}\n"},
+		{YAML, `{"yes": "no", "n": null, "url": "https://x.test", "empty": {}, "nested": [[1, 2]]}`,
+			"\"yes\": \"no\"\n\"n\": null\nurl: \"https://x.test\"\nempty: {}\nnested:\n-\n  - 1\n  - 2\n"},
+		{YAML, `"2024-01-01"`, "\"2024-01-01\"\n"},
+	} {
+		var buf bytes.Buffer
+		if err := Render(&buf, tc.format, []byte(tc.data), nil); err != nil {
+			t.Fatalf("%s %s: %v", tc.format, tc.data, err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s %s:\n%q\nwant:\n%q", tc.format, tc.data, buf.String(), tc.want)
+		}
+	}
+	if err := Render(&bytes.Buffer{}, Table, []byte("not json"), nil); err == nil || !strings.Contains(err.Error(), "result is not JSON") {
+		t.Fatalf("expected JSON error, got %v", err)
+	}
+}
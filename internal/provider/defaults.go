@@ -10,7 +10,7 @@ import (
 	"github.com/patrickjm/api-cli/internal/config"
 )
 
-//go:embed defaults/*.js
+//go:embed defaults/*.js defaults/lib/*.js
 var defaultProviders embed.FS
 
 func EnsureDefaults(base string) error {
@@ -20,17 +20,30 @@ func EnsureDefaults(base string) error {
 	}
 	for _, entry := range entries {
 		name := strings.TrimSuffix(filepath.Base(entry), ".js")
-		target := config.ProviderPath(base, name)
-		if _, err := os.Stat(target); err == nil {
-			continue
-		}
-		contents, err := fs.ReadFile(defaultProviders, entry)
-		if err != nil {
+		if err := ensureDefault(entry, config.ProviderPath(base, name)); err != nil {
 			return err
 		}
-		if err := SaveProvider(target, contents); err != nil {
+	}
+	libs, err := fs.Glob(defaultProviders, "defaults/lib/*.js")
+	if err != nil {
+		return err
+	}
+	for _, entry := range libs {
+		name := strings.TrimSuffix(filepath.Base(entry), ".js")
+		if err := ensureDefault(entry, config.LibPath(base, name)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func ensureDefault(entry, target string) error {
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	contents, err := fs.ReadFile(defaultProviders, entry)
+	if err != nil {
+		return err
+	}
+	return SaveProvider(target, contents)
+}
@@ -1,3 +1,5 @@
+import { qs } from "lib/http.js";
+
 function baseUrl() {
   return env("ALPACA_BASE_URL") || env("ALPACA_ENDPOINT") || "https://paper-api.alpaca.markets";
 }
@@ -13,48 +15,53 @@ function authHeaders() {
   };
 }
 
-function qs(params) {
-  const parts = [];
-  for (const key in params) {
-    if (params[key] === undefined || params[key] === null || params[key] === "") continue;
-    parts.push(encodeURIComponent(key) + "=" + encodeURIComponent(params[key]));
-  }
-  return parts.length ? "?" + parts.join("&") : "";
-}
-
 export default {
+  rateLimit: "200/min",
+  beforeRequest: (req) => {
+    req.headers = Object.assign(authHeaders(), req.headers);
+  },
   "account.get": {
     desc: "Get account details",
     args: [],
-    run: () => fetch(baseUrl() + "/v2/account", { headers: authHeaders() }),
+    run: () => fetch(baseUrl() + "/v2/account"),
   },
   "assets.list": {
     desc: "List assets",
     args: ["status", "asset_class", "exchange"],
+    cache: "1h",
     run: (params) => fetch(baseUrl() + "/v2/assets" + qs({
       status: params.status,
       asset_class: params.asset_class,
       exchange: params.exchange,
-    }), { headers: authHeaders() }),
+    })),
   },
   "assets.get": {
     desc: "Get asset by id or symbol",
-    args: ["id"],
-    run: (params) => fetch(baseUrl() + "/v2/assets/" + params.id, { headers: authHeaders() }),
+    args: [{ name: "id", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/assets/" + params.id),
   },
   "clock": {
     desc: "Get market clock",
     args: [],
-    run: () => fetch(baseUrl() + "/v2/clock", { headers: authHeaders() }),
+    run: () => fetch(baseUrl() + "/v2/clock"),
   },
   "calendar": {
     desc: "Get market calendar",
     args: ["start", "end"],
-    run: (params) => fetch(baseUrl() + "/v2/calendar" + qs({ start: params.start, end: params.end }), { headers: authHeaders() }),
+    cache: "1h",
+    run: (params) => fetch(baseUrl() + "/v2/calendar" + qs({ start: params.start, end: params.end })),
   },
   "orders.list": {
     desc: "List orders",
-    args: ["status", "limit", "after", "until", "direction", "nested"],
+    columns: ["id", "symbol", "side", "type", "qty", "filled_qty", "status", "submitted_at"],
+    args: [
+      { name: "status", enum: ["open", "closed", "all"] },
+      { name: "limit", type: "integer" },
+      "after",
+      "until",
+      { name: "direction", enum: ["asc", "desc"] },
+      { name: "nested", type: "boolean" },
+    ],
     run: (params) => fetch(baseUrl() + "/v2/orders" + qs({
       status: params.status,
       limit: params.limit,
@@ -62,44 +69,67 @@ export default {
       until: params.until,
       direction: params.direction,
       nested: params.nested,
-    }), { headers: authHeaders() }),
+    })),
   },
   "orders.get": {
     desc: "Get an order",
-    args: ["id"],
-    run: (params) => fetch(baseUrl() + "/v2/orders/" + params.id, { headers: authHeaders() }),
+    args: [{ name: "id", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/orders/" + params.id),
   },
   "orders.create": {
     desc: "Create an order",
-    args: ["symbol", "qty", "notional", "side", "type", "time_in_force", "limit_price", "stop_price", "trail_price", "trail_percent", "extended_hours", "client_order_id", "order_class", "take_profit", "stop_loss"],
+    args: [
+      { name: "symbol", required: true },
+      { name: "qty", type: "number" },
+      { name: "notional", type: "number" },
+      { name: "side", enum: ["buy", "sell"], default: "buy" },
+      { name: "type", enum: ["market", "limit", "stop", "stop_limit", "trailing_stop"], default: "market" },
+      { name: "time_in_force", enum: ["day", "gtc", "opg", "cls", "ioc", "fok"], default: "day" },
+      { name: "limit_price", type: "number" },
+      { name: "stop_price", type: "number" },
+      { name: "trail_price", type: "number" },
+      { name: "trail_percent", type: "number" },
+      { name: "extended_hours", type: "boolean", default: false },
+      "client_order_id",
+      "order_class",
+      { name: "take_profit", type: "json" },
+      { name: "stop_loss", type: "json" },
+    ],
     run: (params) => {
       const body = {
         symbol: params.symbol,
         qty: params.qty,
         notional: params.notional,
-        side: params.side || "buy",
-        type: params.type || "market",
-        time_in_force: params.time_in_force || "day",
+        side: params.side,
+        type: params.type,
+        time_in_force: params.time_in_force,
         limit_price: params.limit_price,
         stop_price: params.stop_price,
         trail_price: params.trail_price,
         trail_percent: params.trail_percent,
-        extended_hours: params.extended_hours === "true",
+        extended_hours: params.extended_hours,
         client_order_id: params.client_order_id,
         order_class: params.order_class,
-        take_profit: params.take_profit ? JSON.parse(params.take_profit) : undefined,
-        stop_loss: params.stop_loss ? JSON.parse(params.stop_loss) : undefined,
+        take_profit: params.take_profit,
+        stop_loss: params.stop_loss,
       };
       return fetch(baseUrl() + "/v2/orders", {
         method: "POST",
-        headers: Object.assign({ "Content-Type": "application/json" }, authHeaders()),
         body: body,
       });
     },
   },
   "orders.replace": {
     desc: "Replace an order",
-    args: ["id", "qty", "time_in_force", "limit_price", "stop_price", "trail", "client_order_id"],
+    args: [
+      { name: "id", required: true },
+      { name: "qty", type: "number" },
+      { name: "time_in_force", enum: ["day", "gtc", "opg", "cls", "ioc", "fok"] },
+      { name: "limit_price", type: "number" },
+      { name: "stop_price", type: "number" },
+      { name: "trail", type: "number" },
+      "client_order_id",
+    ],
     run: (params) => {
       const body = {
         qty: params.qty,
@@ -111,40 +141,43 @@ export default {
       };
       return fetch(baseUrl() + "/v2/orders/" + params.id, {
         method: "PATCH",
-        headers: Object.assign({ "Content-Type": "application/json" }, authHeaders()),
         body: body,
       });
     },
   },
   "orders.cancel": {
     desc: "Cancel an order",
-    args: ["id"],
-    run: (params) => fetch(baseUrl() + "/v2/orders/" + params.id, {
-      method: "DELETE",
-      headers: authHeaders(),
-    }),
+    args: [{ name: "id", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/orders/" + params.id, { method: "DELETE" }),
   },
   "positions.list": {
     desc: "List positions",
     args: [],
-    run: () => fetch(baseUrl() + "/v2/positions", { headers: authHeaders() }),
+    columns: ["symbol", "qty", "side", "avg_entry_price", "current_price", "market_value", "unrealized_pl"],
+    run: () => fetch(baseUrl() + "/v2/positions"),
   },
   "positions.get": {
     desc: "Get a position",
-    args: ["symbol"],
-    run: (params) => fetch(baseUrl() + "/v2/positions/" + params.symbol, { headers: authHeaders() }),
+    args: [{ name: "symbol", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/positions/" + params.symbol),
   },
   "positions.close": {
     desc: "Close a position",
-    args: ["symbol"],
-    run: (params) => fetch(baseUrl() + "/v2/positions/" + params.symbol, {
-      method: "DELETE",
-      headers: authHeaders(),
-    }),
+    args: [{ name: "symbol", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/positions/" + params.symbol, { method: "DELETE" }),
   },
   "activities.list": {
     desc: "List account activities",
-    args: ["activity_types", "date", "until", "after", "direction", "page_size", "page_token"],
+    args: [
+      "activity_types",
+      "date",
+      "until",
+      "after",
+      { name: "direction", enum: ["asc", "desc"] },
+      { name: "page_size", type: "integer" },
+      "page_token",
+    ],
+    pagination: { nextFromItem: "id", param: "page_token" },
     run: (params) => fetch(baseUrl() + "/v2/account/activities" + qs({
       activity_types: params.activity_types,
       date: params.date,
@@ -153,72 +186,77 @@ export default {
       direction: params.direction,
       page_size: params.page_size,
       page_token: params.page_token,
-    }), { headers: authHeaders() }),
+    })),
   },
   "watchlists.list": {
     desc: "List watchlists",
     args: [],
-    run: () => fetch(baseUrl() + "/v2/watchlists", { headers: authHeaders() }),
+    run: () => fetch(baseUrl() + "/v2/watchlists"),
   },
   "watchlists.get": {
     desc: "Get a watchlist",
-    args: ["id"],
-    run: (params) => fetch(baseUrl() + "/v2/watchlists/" + params.id, { headers: authHeaders() }),
+    args: [{ name: "id", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/watchlists/" + params.id),
   },
   "watchlists.create": {
     desc: "Create a watchlist",
-    args: ["name", "symbols"],
+    args: [{ name: "name", required: true }, { name: "symbols", type: "array" }],
     run: (params) => {
       const body = {
         name: params.name,
-        symbols: params.symbols ? params.symbols.split(",") : undefined,
+        symbols: params.symbols,
       };
       return fetch(baseUrl() + "/v2/watchlists", {
         method: "POST",
-        headers: Object.assign({ "Content-Type": "application/json" }, authHeaders()),
         body: body,
       });
     },
   },
   "watchlists.add": {
     desc: "Add a symbol to a watchlist",
-    args: ["id", "symbol"],
+    args: [{ name: "id", required: true }, { name: "symbol", required: true }],
     run: (params) => {
       const body = { symbol: params.symbol };
       return fetch(baseUrl() + "/v2/watchlists/" + params.id, {
         method: "POST",
-        headers: Object.assign({ "Content-Type": "application/json" }, authHeaders()),
         body: body,
       });
     },
   },
   "watchlists.delete": {
     desc: "Delete a watchlist",
-    args: ["id"],
-    run: (params) => fetch(baseUrl() + "/v2/watchlists/" + params.id, {
-      method: "DELETE",
-      headers: authHeaders(),
-    }),
+    args: [{ name: "id", required: true }],
+    run: (params) => fetch(baseUrl() + "/v2/watchlists/" + params.id, { method: "DELETE" }),
   },
   "data.stocks.quote": {
     desc: "Get latest stock quote",
-    args: ["symbol"],
-    run: (params) => fetch(dataBaseUrl() + "/v2/stocks/" + params.symbol + "/quotes/latest", { headers: authHeaders() }),
+    args: [{ name: "symbol", required: true }],
+    run: (params) => fetch(dataBaseUrl() + "/v2/stocks/" + params.symbol + "/quotes/latest"),
   },
   "data.stocks.trade": {
     desc: "Get latest stock trade",
-    args: ["symbol"],
-    run: (params) => fetch(dataBaseUrl() + "/v2/stocks/" + params.symbol + "/trades/latest", { headers: authHeaders() }),
+    args: [{ name: "symbol", required: true }],
+    run: (params) => fetch(dataBaseUrl() + "/v2/stocks/" + params.symbol + "/trades/latest"),
   },
   "data.stocks.bars": {
     desc: "Get stock bars",
-    args: ["symbol", "timeframe", "start", "end", "limit", "adjustment"],
+    args: [
+      { name: "symbol", required: true },
+      { name: "timeframe", default: "1Day" },
+      "start",
+      "end",
+      { name: "limit", type: "integer" },
+      { name: "adjustment", enum: ["raw", "split", "dividend", "all"] },
+      "page_token",
+    ],
+    pagination: { items: "bars", next: "next_page_token", param: "page_token" },
     run: (params) => fetch(dataBaseUrl() + "/v2/stocks/" + params.symbol + "/bars" + qs({
-      timeframe: params.timeframe || "1Day",
+      timeframe: params.timeframe,
       start: params.start,
       end: params.end,
       limit: params.limit,
       adjustment: params.adjustment,
-    }), { headers: authHeaders() }),
+      page_token: params.page_token,
+    })),
   },
 };
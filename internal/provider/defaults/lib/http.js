@@ -0,0 +1,13 @@
+export function qs(params) {
+  const parts = [];
+  for (const key in params) {
+    if (params[key] === undefined || params[key] === null || params[key] === "") continue;
+    parts.push(encodeURIComponent(key) + "=" + encodeURIComponent(params[key]));
+  }
+  return parts.length ? "?" + parts.join("&") : "";
+}
+
+export function parseJSON(value, fallback) {
+  if (!value) return fallback;
+  return JSON.parse(value);
+}
@@ -1,3 +1,5 @@
+import { buildMessages } from "lib/chat.js";
+
 function apiBase() {
   return env("OPENROUTER_BASE_URL") || "https://openrouter.ai/api/v1";
 }
@@ -11,46 +13,51 @@ function authHeaders() {
   return headers;
 }
 
-function parseJSON(value, fallback) {
-  if (!value) return fallback;
-  return JSON.parse(value);
-}
-
-function buildMessages(params) {
-  if (params.messages) return parseJSON(params.messages, []);
-  const out = [];
-  if (params.system) {
-    out.push({ role: "system", content: params.system });
-  }
-  if (params.q) {
-    out.push({ role: "user", content: params.q });
-  }
-  return out;
-}
-
 export default {
   chat: {
     desc: "Chat completion",
-    args: ["q", "model", "system", "messages", "temperature", "top_p", "max_tokens", "stream"],
+    args: [
+      "q",
+      "model",
+      "system",
+      { name: "messages", type: "json" },
+      { name: "temperature", type: "number" },
+      { name: "top_p", type: "number" },
+      { name: "max_tokens", type: "integer" },
+      { name: "stream", type: "boolean", default: false },
+    ],
     run: (params) => {
       const body = {
         model: params.model || env("OPENROUTER_MODEL") || "openai/gpt-4o-mini",
         messages: buildMessages(params),
-        temperature: params.temperature ? Number(params.temperature) : undefined,
-        top_p: params.top_p ? Number(params.top_p) : undefined,
-        max_tokens: params.max_tokens ? Number(params.max_tokens) : undefined,
-        stream: params.stream === "true",
+        temperature: params.temperature,
+        top_p: params.top_p,
+        max_tokens: params.max_tokens,
+        stream: params.stream,
       };
-      return fetch(apiBase() + "/chat/completions", {
+      const opts = {
         method: "POST",
         headers: Object.assign({ "Content-Type": "application/json" }, authHeaders()),
         body: body,
-      });
+      };
+      if (!body.stream) {
+        return fetch(apiBase() + "/chat/completions", opts);
+      }
+      opts.stream = true;
+      opts.onEvent = (event) => {
+        if (event.data === "[DONE]") return false;
+        const choice = event.json && event.json.choices && event.json.choices[0];
+        if (choice && choice.delta && choice.delta.content) write(choice.delta.content);
+      };
+      const resp = fetch(apiBase() + "/chat/completions", opts);
+      if (resp.status >= 400) return resp;
+      write("\n");
     },
   },
   "models.list": {
     desc: "List models",
     args: [],
+    cache: "1h",
     run: () => fetch(apiBase() + "/models", { headers: authHeaders() }),
   },
 };
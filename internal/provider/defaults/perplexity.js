@@ -1,3 +1,5 @@
+import { buildMessages } from "lib/chat.js";
+
 function apiBase() {
   return env("PERPLEXITY_BASE_URL") || "https://api.perplexity.ai";
 }
@@ -6,34 +8,28 @@ function authHeaders() {
   return { Authorization: "Bearer " + secret("token") };
 }
 
-function parseJSON(value, fallback) {
-  if (!value) return fallback;
-  return JSON.parse(value);
-}
-
-function buildMessages(params) {
-  if (params.messages) return parseJSON(params.messages, []);
-  const out = [];
-  if (params.system) {
-    out.push({ role: "system", content: params.system });
-  }
-  if (params.q) {
-    out.push({ role: "user", content: params.q });
-  }
-  return out;
-}
-
 export default {
   search: {
     desc: "Perplexity search",
-    args: ["q", "max_results", "max_tokens", "max_tokens_per_page", "search_domain_filter", "country", "search_recency_filter", "search_after_date", "search_before_date"],
+    args: [
+      "q",
+      { name: "query", type: "json" },
+      { name: "max_results", type: "integer" },
+      { name: "max_tokens", type: "integer" },
+      { name: "max_tokens_per_page", type: "integer" },
+      { name: "search_domain_filter", type: "json" },
+      "country",
+      { name: "search_recency_filter", enum: ["hour", "day", "week", "month", "year"] },
+      "search_after_date",
+      "search_before_date",
+    ],
     run: (params) => {
       const body = {
-        query: params.q || parseJSON(params.query, ""),
-        max_results: params.max_results ? Number(params.max_results) : undefined,
-        max_tokens: params.max_tokens ? Number(params.max_tokens) : undefined,
-        max_tokens_per_page: params.max_tokens_per_page ? Number(params.max_tokens_per_page) : undefined,
-        search_domain_filter: parseJSON(params.search_domain_filter, undefined),
+        query: params.q || params.query || "",
+        max_results: params.max_results,
+        max_tokens: params.max_tokens,
+        max_tokens_per_page: params.max_tokens_per_page,
+        search_domain_filter: params.search_domain_filter,
         country: params.country,
         search_recency_filter: params.search_recency_filter,
         search_after_date: params.search_after_date,
@@ -48,18 +44,36 @@ export default {
   },
   ask: {
     desc: "Chat completion with sonar",
-    args: ["q", "model", "system", "messages", "search_mode", "temperature", "top_p", "max_tokens", "return_images", "return_related_questions", "search_domain_filter", "search_recency_filter", "search_after_date_filter", "search_before_date_filter", "last_updated_after_filter", "last_updated_before_filter"],
+    defaultQuery: ".choices[0].message.content",
+    args: [
+      "q",
+      { name: "model", default: "sonar-pro" },
+      "system",
+      { name: "messages", type: "json" },
+      { name: "search_mode", default: "web" },
+      { name: "temperature", type: "number", default: 0.2 },
+      { name: "top_p", type: "number", default: 0.9 },
+      { name: "max_tokens", type: "integer" },
+      { name: "return_images", type: "boolean", default: false },
+      { name: "return_related_questions", type: "boolean", default: false },
+      { name: "search_domain_filter", type: "json" },
+      { name: "search_recency_filter", enum: ["hour", "day", "week", "month", "year"] },
+      "search_after_date_filter",
+      "search_before_date_filter",
+      "last_updated_after_filter",
+      "last_updated_before_filter",
+    ],
     run: (params) => {
       const body = {
-        model: params.model || "sonar-pro",
+        model: params.model,
         messages: buildMessages(params),
-        search_mode: params.search_mode || "web",
-        temperature: params.temperature ? Number(params.temperature) : 0.2,
-        top_p: params.top_p ? Number(params.top_p) : 0.9,
-        max_tokens: params.max_tokens ? Number(params.max_tokens) : undefined,
-        return_images: params.return_images === "true",
-        return_related_questions: params.return_related_questions === "true",
-        search_domain_filter: parseJSON(params.search_domain_filter, undefined),
+        search_mode: params.search_mode,
+        temperature: params.temperature,
+        top_p: params.top_p,
+        max_tokens: params.max_tokens,
+        return_images: params.return_images,
+        return_related_questions: params.return_related_questions,
+        search_domain_filter: params.search_domain_filter,
         search_recency_filter: params.search_recency_filter,
         search_after_date_filter: params.search_after_date_filter,
         search_before_date_filter: params.search_before_date_filter,
@@ -75,19 +89,37 @@ export default {
   },
   deep: {
     desc: "Deep research chat completion",
-    args: ["q", "model", "system", "messages", "search_mode", "reasoning_effort", "temperature", "top_p", "max_tokens", "return_images", "return_related_questions", "search_domain_filter", "search_recency_filter", "search_after_date_filter", "search_before_date_filter", "last_updated_after_filter", "last_updated_before_filter"],
+    args: [
+      "q",
+      { name: "model", default: "sonar-deep-research" },
+      "system",
+      { name: "messages", type: "json" },
+      { name: "search_mode", default: "web" },
+      { name: "reasoning_effort", enum: ["low", "medium", "high"], default: "medium" },
+      { name: "temperature", type: "number", default: 0.2 },
+      { name: "top_p", type: "number", default: 0.9 },
+      { name: "max_tokens", type: "integer" },
+      { name: "return_images", type: "boolean", default: false },
+      { name: "return_related_questions", type: "boolean", default: false },
+      { name: "search_domain_filter", type: "json" },
+      { name: "search_recency_filter", enum: ["hour", "day", "week", "month", "year"] },
+      "search_after_date_filter",
+      "search_before_date_filter",
+      "last_updated_after_filter",
+      "last_updated_before_filter",
+    ],
     run: (params) => {
       const body = {
-        model: params.model || "sonar-deep-research",
+        model: params.model,
         messages: buildMessages(params),
-        search_mode: params.search_mode || "web",
-        reasoning_effort: params.reasoning_effort || "medium",
-        temperature: params.temperature ? Number(params.temperature) : 0.2,
-        top_p: params.top_p ? Number(params.top_p) : 0.9,
-        max_tokens: params.max_tokens ? Number(params.max_tokens) : undefined,
-        return_images: params.return_images === "true",
-        return_related_questions: params.return_related_questions === "true",
-        search_domain_filter: parseJSON(params.search_domain_filter, undefined),
+        search_mode: params.search_mode,
+        reasoning_effort: params.reasoning_effort,
+        temperature: params.temperature,
+        top_p: params.top_p,
+        max_tokens: params.max_tokens,
+        return_images: params.return_images,
+        return_related_questions: params.return_related_questions,
+        search_domain_filter: params.search_domain_filter,
         search_recency_filter: params.search_recency_filter,
         search_after_date_filter: params.search_after_date_filter,
         search_before_date_filter: params.search_before_date_filter,
@@ -1,3 +1,5 @@
+import { qs } from "lib/http.js";
+
 function apiBase() {
   return env("REPLICATE_BASE_URL") || "https://api.replicate.com/v1";
 }
@@ -6,20 +8,6 @@ function authHeaders() {
   return { Authorization: "Bearer " + secret("token") };
 }
 
-function parseJSON(value, fallback) {
-  if (!value) return fallback;
-  return JSON.parse(value);
-}
-
-function qs(params) {
-  const parts = [];
-  for (const key in params) {
-    if (params[key] === undefined || params[key] === null || params[key] === "") continue;
-    parts.push(encodeURIComponent(key) + "=" + encodeURIComponent(params[key]));
-  }
-  return parts.length ? "?" + parts.join("&") : "";
-}
-
 function waitHeader(params) {
   if (!params.wait) return null;
   if (params.wait === "true") return "wait=60";
@@ -34,12 +22,17 @@ function fetchJSON(path, opts) {
 export default {
   search: {
     desc: "Search models, collections, docs",
-    args: ["q", "limit"],
+    args: [{ name: "q", required: true }, { name: "limit", type: "integer" }],
     run: (params) => fetchJSON("/search" + qs({ query: params.q, limit: params.limit }), { headers: authHeaders() }),
   },
   "models.list": {
     desc: "List models",
-    args: ["cursor", "sort_by", "sort_direction"],
+    args: [
+      "cursor",
+      { name: "sort_by", enum: ["model_created_at", "latest_version_created_at"] },
+      { name: "sort_direction", enum: ["asc", "desc"] },
+    ],
+    pagination: { style: "link", items: "results", next: "next", param: "cursor" },
     run: (params) => fetchJSON("/models" + qs({
       cursor: params.cursor,
       sort_by: params.sort_by,
@@ -48,27 +41,48 @@ export default {
   },
   "models.get": {
     desc: "Get model",
-    args: ["owner", "name"],
+    args: [{ name: "owner", required: true }, { name: "name", required: true }],
     run: (params) => fetchJSON("/models/" + params.owner + "/" + params.name, { headers: authHeaders() }),
   },
   "models.examples": {
     desc: "List model examples",
-    args: ["owner", "name"],
+    args: [{ name: "owner", required: true }, { name: "name", required: true }],
     run: (params) => fetchJSON("/models/" + params.owner + "/" + params.name + "/examples", { headers: authHeaders() }),
   },
   "models.versions": {
     desc: "List model versions",
-    args: ["owner", "name"],
+    args: [{ name: "owner", required: true }, { name: "name", required: true }],
     run: (params) => fetchJSON("/models/" + params.owner + "/" + params.name + "/versions", { headers: authHeaders() }),
   },
   "models.version": {
     desc: "Get model version",
-    args: ["owner", "name", "version"],
+    args: [
+      { name: "owner", required: true },
+      { name: "name", required: true },
+      { name: "version", required: true },
+    ],
     run: (params) => fetchJSON("/models/" + params.owner + "/" + params.name + "/versions/" + params.version, { headers: authHeaders() }),
   },
+  "files.upload": {
+    desc: "Upload a local file for use as a prediction input",
+    args: [{ name: "path", required: true }, { name: "type", desc: "content type" }],
+    run: (params) => {
+      const form = new FormData();
+      form.append("content", file(params.path, { type: params.type }));
+      return fetchJSON("/files", { method: "POST", headers: authHeaders(), body: form });
+    },
+  },
   "predictions.create": {
     desc: "Create prediction",
-    args: ["version", "input", "wait", "cancel_after", "webhook", "webhook_events_filter"],
+    args: [
+      { name: "version", required: true },
+      { name: "input", type: "json" },
+      { name: "input_file", desc: "path to a JSON file with the input" },
+      "wait",
+      "cancel_after",
+      "webhook",
+      { name: "webhook_events_filter", type: "array" },
+    ],
     run: (params) => {
       const headers = Object.assign({ "Content-Type": "application/json" }, authHeaders());
       const prefer = waitHeader(params);
@@ -79,32 +93,51 @@ export default {
         headers: headers,
         body: {
           version: params.version,
-          input: parseJSON(params.input, undefined),
+          input: params.input_file ? JSON.parse(readFile(params.input_file)) : params.input,
           webhook: params.webhook,
-          webhook_events_filter: parseJSON(params.webhook_events_filter, undefined),
+          webhook_events_filter: params.webhook_events_filter,
         },
       });
     },
   },
   "predictions.get": {
     desc: "Get prediction",
-    args: ["id"],
+    args: [{ name: "id", required: true }],
     run: (params) => fetchJSON("/predictions/" + params.id, { headers: authHeaders() }),
   },
   "predictions.cancel": {
     desc: "Cancel prediction",
-    args: ["id"],
+    args: [{ name: "id", required: true }],
     run: (params) => fetchJSON("/predictions/" + params.id + "/cancel", {
       method: "POST",
       headers: authHeaders(),
     }),
   },
+  "predictions.download": {
+    desc: "Save prediction output files to a directory",
+    args: [{ name: "id", required: true }, { name: "dir", default: "." }],
+    run: (params) => {
+      const pred = fetchJSON("/predictions/" + params.id, { headers: authHeaders() });
+      const urls = [].concat(pred.output || []).filter((o) => typeof o === "string" && /^https?:/.test(o));
+      return urls.map((url) => {
+        const resp = fetch(url);
+        if (!resp.ok) throw new Error("download failed with status " + resp.status + ": " + url);
+        const path = params.dir + "/" + url.split("?")[0].split("/").pop();
+        writeFile(path, resp.body);
+        return path;
+      });
+    },
+  },
   "predictions.wait": {
     desc: "Poll prediction until done",
-    args: ["id", "poll_ms", "timeout_s"],
+    args: [
+      { name: "id", required: true },
+      { name: "poll_ms", type: "integer", default: 2000 },
+      { name: "timeout_s", type: "number", default: 300 },
+    ],
     run: (params) => {
-      const pollMs = params.poll_ms ? Number(params.poll_ms) : 2000;
-      const timeoutMs = params.timeout_s ? Number(params.timeout_s) * 1000 : 300000;
+      const pollMs = params.poll_ms;
+      const timeoutMs = params.timeout_s * 1000;
       const start = Date.now();
       while (true) {
         const pred = fetchJSON("/predictions/" + params.id, { headers: authHeaders() });
@@ -0,0 +1,62 @@
+// Package query evaluates jq filters against JSON values using gojq, a
+// pure-Go implementation of the jq language.
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/itchyny/gojq"
+)
+
+// Query is a parsed filter.
+type Query struct {
+	src  string
+	code *gojq.Code
+}
+
+// Parse parses and compiles a jq filter.
+func Parse(src string) (*Query, error) {
+	parsed, err := gojq.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %w", src, err)
+	}
+	code, err := gojq.Compile(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query %q: %w", src, err)
+	}
+	return &Query{src: src, code: code}, nil
+}
+
+// Run applies the filter to v, a value decoded by Decode, and returns every
+// output.
+func (q *Query) Run(v any) ([]any, error) {
+	out := []any{}
+	iter := q.code.Run(v)
+	for {
+		value, ok := iter.Next()
+		if !ok {
+			return out, nil
+		}
+		if err, ok := value.(error); ok {
+			if err, ok := err.(*gojq.HaltError); ok && err.Value() == nil {
+				return out, nil
+			}
+			return nil, fmt.Errorf("query %q: %w", q.src, err)
+		}
+		out = append(out, value)
+	}
+}
+
+// Decode parses JSON, keeping numbers as json.Number so large IDs pass
+// through unchanged.
+func Decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,168 @@
+package query
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	input, err := Decode([]byte(`{
+  "id": 12345678901234567890,
+  "choices": [{"message": {"role": "assistant", "content": "Hello"}}],
+  "positions": [
+    {"symbol": "AAPL", "qty": "10", "market_value": 1900.5, "side": "long"},
+    {"symbol": "TSLA", "qty": "2", "market_value": 500, "side": "short"},
+    {"symbol": "MSFT", "qty": "5", "market_value": 2100, "side": "long"}
+  ],
+  "tags": ["a", "b"],
+  "empty": null
+}`))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	// Outputs match jq 1.7 (jq -c), except that object keys come out sorted,
+	// since Decode reads objects into maps, and large integers such as id
+	// keep every digit instead of rounding to a float64.
+	for _, tc := range []struct{ query, want string }{
+		{`.`, ""},
+		{`.choices[0].message.content`, `"Hello"`},
+		{`.choices[].message | .role, .content`, `"assistant" "Hello"`},
+		{`.id`, `12345678901234567890`},
+		{`.missing.deeper`, `null`},
+		{`."choices"[-1].message["role"]`, `"assistant"`},
+		{`.positions[1:][0].symbol`, `"TSLA"`},
+		{`.tags[:1]`, `["a"]`},
+		{`.positions | length`, `3`},
+		{`[.positions[] | select(.side == "long") | .symbol]`, `["AAPL","MSFT"]`},
+		{`.positions | map({symbol, value: .market_value})`, `[{"symbol":"AAPL","value":1900.5},{"symbol":"TSLA","value":500},{"symbol":"MSFT","value":2100}]`},
+		{`.positions | map(.qty | tonumber) | add`, `17`},
+		{`.positions | sort_by(.market_value) | map(.symbol) | join(",")`, `"TSLA,AAPL,MSFT"`},
+		{`.positions | group_by(.side) | map(length)`, `[2,1]`},
+		{`.positions | max_by(.market_value).symbol`, `"MSFT"`},
+		{`.positions[0] | keys`, `["market_value","qty","side","symbol"]`},
+		{`.positions[0] | to_entries[0]`, `{"key":"market_value","value":1900.5}`},
+		{`.positions[0] | with_entries(select(.key | startswith("s")))`, `{"side":"long","symbol":"AAPL"}`},
+		{`.empty // "none"`, `"none"`},
+		{`.tags | if length > 1 then "many" elif length == 1 then "one" else "none" end`, `"many"`},
+		{`.positions[] | select(.market_value > 1000 and .side != "short") | .symbol | ascii_downcase`, `"aapl" "msft"`},
+		{`{(.tags[]): 1}`, `{"a":1} {"b":1}`},
+		{`[.tags[] + "!"] + ["c"]`, `["a!","b!","c"]`},
+		{`(1 + 2) * 3 - 4 / 2, 7 % 3, -(1)`, `7 1 -1`},
+		{`.choices[0].message.content | test("^H") and contains("ell")`, `true`},
+		{`.positions | any(.side == "short"), all(.qty | tonumber > 1)`, `true true`},
+		{`[limit(2; .positions[].symbol)], [range(3)], first(.tags[])`, `["AAPL","TSLA"] [0,1,2] "a"`},
+		{`[.[] | numbers]`, `[12345678901234567890]`},
+		{`.id?, (.tags.x)?, "after"`, `12345678901234567890 "after"`},
+		{`.tags | tojson | fromjson | unique | reverse`, `["b","a"]`},
+		{`"a,b" | split(",") | length`, `2`},
+		{`[..|strings] | length`, `13`},
+		{`try error("boom") catch .`, `"boom"`},
+		{`try (.tags.x) catch "caught"`, `"caught"`},
+		{`.positions as $p | $p | length`, `3`},
+		{`.positions[] as {symbol: $s, qty: $q} | "\($s)=\($q)"`, `"AAPL=10" "TSLA=2" "MSFT=5"`},
+		{`reduce .positions[] as $p (0; . + $p.market_value)`, `4500.5`},
+		{`[foreach .tags[] as $t (""; . + $t)]`, `["a","ab"]`},
+		{`.choices[0].message.content | @base64`, `"SGVsbG8="`},
+		{`.choices[0].message.content | @base64 | @base64d`, `"Hello"`},
+		{`.positions[] | [.symbol, .qty, .market_value] | @csv`, `"\"AAPL\",\"10\",1900.5" "\"TSLA\",\"2\",500" "\"MSFT\",\"5\",2100"`},
+		{`.positions[0] | [.symbol, .side] | @tsv`, `"AAPL\tlong"`},
+		{`.tags | @json`, `"[\"a\",\"b\"]"`},
+		{`"x" | @uri, @html, @sh`, `"x" "x" "'x'"`},
+		{`.positions[0] | del(.qty, .side)`, `{"market_value":1900.5,"symbol":"AAPL"}`},
+		{`[paths] | length`, `26`},
+		{`[paths(type == "number")]`, `[["id"],["positions",0,"market_value"],["positions",1,"market_value"],["positions",2,"market_value"]]`},
+		{`.positions[0] | getpath(["symbol"]), setpath(["qty"]; "11").qty`, `"AAPL" "11"`},
+		{`.tags | index("b"), (map(ascii_upcase) | join("-"))`, `1 "A-B"`},
+		{`"1e300" as $n | "abc" | .[1:]`, `"bc"`},
+		{`[.tags[] | select(. == "a")] | length`, `1`},
+		{`.positions | map(.market_value) | min, max, add / length`, `500 2100 1500.1666666666667`},
+		{`.positions | INDEX(.symbol) | keys`, `["AAPL","MSFT","TSLA"]`},
+		{`[.positions[] | .market_value | floor, sqrt] | length`, `6`},
+		{`"2024-01-15T10:00:00Z" | fromdateiso8601`, `1705312800`},
+		{`.positions | map(select(.symbol | IN("AAPL", "MSFT"))) | length`, `2`},
+		{`"a b  c" | [splits(" +")]`, `["a","b","c"]`},
+		{`"hello" | ascii_upcase | ltrimstr("HE") | rtrimstr("O")`, `"LL"`},
+		{`.positions | map(has("side")) | all`, `true`},
+		{`{a: 1} * {b: {c: 2}} | tostream`, `[["a"],1] [["b","c"],2] [["b","c"]] [["b"]]`},
+		{`[.positions[] | .qty | tonumber] | sort | reverse`, `[10,5,2]`},
+		{`.positions | to_entries | map(.key) | @text`, `"[0,1,2]"`},
+		{`[range(0; 10; 3)]`, `[0,3,6,9]`},
+		{`"abc" | explode | implode`, `"abc"`},
+		{`(.positions | length) as $n | [range($n)] | map(. * 2)`, `[0,2,4]`},
+		{`"test" | ltrimstr("t") | length`, `3`},
+		{`.positions | first, last | .symbol`, `"AAPL" "MSFT"`},
+		{`[.positions[] | objects] | length`, `3`},
+		{`.tags | map(type)`, `["string","string"]`},
+		{`[recurse(if . < 3 then . + 1 else empty end)] | length`, `1`},
+		{`.positions | map(.symbol) | contains(["AAPL"]), inside(["AAPL","TSLA","MSFT","X"])`, `true true`},
+		{`.tags | tostring`, `"[\"a\",\"b\"]"`},
+		{`{} | .a.b.c = 1`, `{"a":{"b":{"c":1}}}`},
+		{`.positions | map(.market_value) | sort_by(-.)`, `[2100,1900.5,500]`},
+		{`[.positions[] | {(.symbol): .qty}] | add`, `{"AAPL":"10","MSFT":"5","TSLA":"2"}`},
+		{`.positions | map(.symbol) | join(", ") | length`, `16`},
+		{`"10" | tonumber + 1`, `11`},
+		{`[1,[2,[3]]] | flatten, flatten(1)`, `[1,2,3] [1,2,[3]]`},
+		{`{"a":null} | .a // "default"`, `"default"`},
+		{`[.[] | scalars] | length`, `2`},
+		{`.positions | group_by(.side) | map({side: .[0].side, count: length})`, `[{"count":2,"side":"long"},{"count":1,"side":"short"}]`},
+		{`"ab" * 3`, `"ababab"`},
+		{`[1,2,3] | .[1:] | length`, `2`},
+		{`[1,2] - [2]`, `[1]`},
+		{`{"a":1,"b":2} | map_values(. + 1)`, `{"a":2,"b":3}`},
+		{`[1,2,3] | del(.[0])`, `[2,3]`},
+		{`[3,1,2] | sort, min_by(.), unique_by(. % 2)`, `[1,2,3] 1 [2,3]`},
+		{`"a-b_c" | gsub("[-_]"; " ")`, `"a b c"`},
+		{`"abc123" | capture("(?<letters>[a-z]+)(?<digits>[0-9]+)")`, `{"digits":"123","letters":"abc"}`},
+		{`"abc" | sub("b"; "B")`, `"aBc"`},
+		{`"x=1&y=2" | [scan("[a-z]=[0-9]")]`, `["x=1","y=2"]`},
+		{`@text "v: \(.tags[0])"`, `"v: a"`},
+		{`.tags | length as $l | "len \($l)"`, `"len 2"`},
+		{`[limit(3; repeat(1))]`, `[1,1,1]`},
+		{`{} | .a += 1`, `{"a":1}`},
+		{`[1, 2] | .[0] |= . + 10`, `[11,2]`},
+		{`.tags | any`, `true`},
+		{`[getpath(["positions", 0, "symbol"], ["nope"])]`, `["AAPL",null]`},
+		{`"abcdef" | .[1e300:], .[-1e300:]`, `"" "abcdef"`},
+	} {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Fatalf("Parse(%s) error: %v", tc.query, err)
+		}
+		out, err := q.Run(input)
+		if err != nil {
+			t.Fatalf("Run(%s) error: %v", tc.query, err)
+		}
+		var parts []string
+		for _, v := range out {
+			b, _ := json.Marshal(v)
+			parts = append(parts, string(b))
+		}
+		got := strings.Join(parts, " ")
+		if tc.want == "" {
+			b, _ := json.Marshal(input)
+			tc.want = string(b)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.query, tc.want, got)
+		}
+	}
+
+	for _, tc := range []struct{ query, err string }{
+		{`.tags.x`, `expected an object but got: array (["a","b"])`},
+		{`.choices[0].message.content[]`, `cannot iterate over: string ("Hello")`},
+		{`.tags | nope`, `function not defined: nope/0`},
+		{`.a |`, `invalid query ".a |": unexpected EOF`},
+		{`.a )`, `unexpected token ")"`},
+		{`{"a": 1} - 1`, `cannot subtract: object ({"a":1}) and number (1)`},
+		{`error("custom")`, `error: custom`},
+	} {
+		q, err := Parse(tc.query)
+		if err == nil {
+			_, err = q.Run(input)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("%s: expected error %q, got %v", tc.query, tc.err, err)
+		}
+	}
+}
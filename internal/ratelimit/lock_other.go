@@ -0,0 +1,29 @@
+//go:build !unix
+
+package ratelimit
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// lockFile takes a lock by exclusively creating path, treating locks older
+// than a few seconds as left behind by a crashed process.
+func lockFile(path string) (func(), error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > 5*time.Second {
+			_ = os.Remove(path)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
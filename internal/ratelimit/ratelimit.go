@@ -0,0 +1,213 @@
+// Package ratelimit implements a token bucket shared by every api process
+// running against the same provider and profile. The bucket lives in a
+// small JSON file guarded by a lock file, so concurrent invocations draw
+// from the same budget.
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// Limit allows Requests per Per, with bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour,
+}
+
+// Parse reads limits like "200/min", "10/s" or "5/2s". An empty string,
+// "0" or "off" returns a zero Limit, which disables limiting.
+func Parse(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: want requests/period like 200/min", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: request count must be a positive integer", s)
+	}
+	per = strings.TrimSpace(per)
+	period, ok := units[per]
+	if !ok {
+		d, err := time.ParseDuration(per)
+		if err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q: unknown period %q", s, per)
+		}
+		period = d
+	}
+	return Limit{Requests: n, Per: period}, nil
+}
+
+func (l Limit) IsZero() bool {
+	return l.Requests <= 0 || l.Per <= 0
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// Limiter throttles requests against the bucket stored at path.
+type Limiter struct {
+	path  string
+	limit Limit
+	// OnWait is called before sleeping for a token.
+	OnWait func(time.Duration)
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+var _ request.Limiter = (*Limiter)(nil)
+
+func New(path string, limit Limit) *Limiter {
+	return &Limiter{path: path, limit: limit, now: time.Now, sleep: time.Sleep}
+}
+
+type state struct {
+	Tokens       float64 `json:"tokens"`
+	Updated      int64   `json:"updated"`
+	BlockedUntil int64   `json:"blockedUntil,omitempty"`
+}
+
+// Wait blocks until a token is available and takes it.
+func (l *Limiter) Wait() error {
+	for {
+		var wait time.Duration
+		err := l.update(func(st *state, now time.Time) {
+			if blocked := time.Unix(0, st.BlockedUntil); now.Before(blocked) {
+				wait = blocked.Sub(now)
+				return
+			}
+			if st.Tokens >= 1 {
+				st.Tokens--
+				return
+			}
+			wait = time.Duration((1 - st.Tokens) / l.rate() * float64(time.Second))
+		})
+		if err != nil || wait <= 0 {
+			return err
+		}
+		if l.OnWait != nil {
+			l.OnWait(wait)
+		}
+		l.sleep(wait)
+	}
+}
+
+// Observe adapts the bucket to the limits the server reports through
+// X-RateLimit-Remaining/Reset (or RateLimit-*) and 429 Retry-After headers.
+func (l *Limiter) Observe(status int, header http.Header) {
+	now := l.now()
+	var blockedUntil time.Time
+	if status == http.StatusTooManyRequests {
+		if after, ok := request.ParseRetryAfter(header.Get("Retry-After"), now); ok {
+			blockedUntil = now.Add(after)
+		}
+	}
+	remaining, hasRemaining := headerInt(header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	if hasRemaining && remaining <= 0 && blockedUntil.IsZero() {
+		if reset, ok := headerInt(header, "X-RateLimit-Reset", "RateLimit-Reset"); ok {
+			blockedUntil = resetTime(reset, now)
+		}
+	}
+	if !hasRemaining && blockedUntil.IsZero() {
+		return
+	}
+	_ = l.update(func(st *state, now time.Time) {
+		if hasRemaining {
+			st.Tokens = math.Min(st.Tokens, float64(max(remaining, 0)))
+		}
+		if blockedUntil.After(now) && blockedUntil.UnixNano() > st.BlockedUntil {
+			st.BlockedUntil = blockedUntil.UnixNano()
+		}
+	})
+}
+
+func (l *Limiter) rate() float64 {
+	return float64(l.limit.Requests) / l.limit.Per.Seconds()
+}
+
+// update refills the bucket and applies fn while holding the lock.
+func (l *Limiter) update(fn func(*state, time.Time)) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := l.now()
+	burst := float64(l.limit.Requests)
+	st := state{Tokens: burst, Updated: now.UnixNano()}
+	if b, err := os.ReadFile(l.path); err == nil {
+		if json.Unmarshal(b, &st) != nil {
+			st = state{Tokens: burst, Updated: now.UnixNano()}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if elapsed := now.Sub(time.Unix(0, st.Updated)); elapsed > 0 {
+		st.Tokens += elapsed.Seconds() * l.rate()
+	}
+	st.Tokens = math.Min(st.Tokens, burst)
+	st.Updated = now.UnixNano()
+	fn(&st, now)
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func headerInt(header http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// resetTime interprets a reset header as epoch milliseconds, epoch seconds
+// or seconds from now, depending on its magnitude.
+func resetTime(reset int64, now time.Time) time.Time {
+	switch {
+	case reset > 1e12:
+		return time.UnixMilli(reset)
+	case reset > 1e9:
+		return time.Unix(reset, 0)
+	default:
+		return now.Add(time.Duration(reset) * time.Second)
+	}
+}
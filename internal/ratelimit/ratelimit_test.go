@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for input, want := range map[string]Limit{
+		"200/min": {Requests: 200, Per: time.Minute},
+		"10/s":    {Requests: 10, Per: time.Second},
+		"5 / 2s":  {Requests: 5, Per: 2 * time.Second},
+		"off":     {},
+		"":        {},
+	} {
+		got, err := Parse(input)
+		if err != nil {
+			t.Fatalf("%q: Parse error: %v", input, err)
+		}
+		if got != want {
+			t.Fatalf("%q: expected %+v, got %+v", input, want, got)
+		}
+	}
+	for _, input := range []string{"200", "x/min", "-1/s", "3/fortnight"} {
+		if _, err := Parse(input); err == nil {
+			t.Fatalf("%q: expected error", input)
+		}
+	}
+}
+
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(path string, limit Limit, clock *fakeClock) *Limiter {
+	l := New(path, limit)
+	l.now = clock.Now
+	l.sleep = clock.Sleep
+	return l
+}
+
+func TestLimiterSharesBucket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit", "test.default.json")
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	limit := Limit{Requests: 2, Per: time.Second}
+	a := newTestLimiter(path, limit, clock)
+	b := newTestLimiter(path, limit, clock)
+
+	if err := a.Wait(); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+	if err := b.Wait(); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+	if len(clock.slept) != 0 {
+		t.Fatalf("expected burst without waiting, slept %v", clock.slept)
+	}
+	if err := a.Wait(); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 500*time.Millisecond {
+		t.Fatalf("expected one 500ms wait for the shared bucket, slept %v", clock.slept)
+	}
+}
+
+func TestLimiterObserveHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.default.json")
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	l := newTestLimiter(path, Limit{Requests: 100, Per: time.Minute}, clock)
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", "1700000030")
+	l.Observe(http.StatusOK, header)
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+	if len(clock.slept) != 1 || clock.slept[0] != 30*time.Second {
+		t.Fatalf("expected to wait for the reported reset, slept %v", clock.slept)
+	}
+
+	clock.slept = nil
+	header = http.Header{}
+	header.Set("Retry-After", "5")
+	l.Observe(http.StatusTooManyRequests, header)
+	if err := l.Wait(); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+	if len(clock.slept) == 0 || clock.slept[0] != 5*time.Second {
+		t.Fatalf("expected to wait for Retry-After, slept %v", clock.slept)
+	}
+}
+
+func TestResetTime(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	if got := resetTime(12, now); !got.Equal(now.Add(12 * time.Second)) {
+		t.Fatalf("expected delta seconds, got %v", got)
+	}
+	if got := resetTime(1_700_000_060, now); !got.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected epoch seconds, got %v", got)
+	}
+	if got := resetTime(1_700_000_002_000, now); !got.Equal(now.Add(2 * time.Second)) {
+		t.Fatalf("expected epoch millis, got %v", got)
+	}
+}
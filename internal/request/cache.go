@@ -0,0 +1,241 @@
+package request
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheMode selects how Do uses the cache.
+type CacheMode int
+
+const (
+	// CacheDefault serves fresh entries and revalidates stale ones.
+	CacheDefault CacheMode = iota
+	// CacheBypass always goes to the network but still stores responses.
+	CacheBypass
+	// CacheOnly serves entries regardless of age and never touches the
+	// network.
+	CacheOnly
+)
+
+// CacheStatusHeader is set on responses handled by the cache to hit, miss
+// or revalidated.
+const CacheStatusHeader = "X-Api-Cache"
+
+// Cache is an on-disk cache for GET and HEAD responses. Freshness follows
+// Cache-Control and Expires unless TTL overrides it, and stale entries with
+// an ETag or Last-Modified are revalidated with a conditional request.
+type Cache struct {
+	Dir  string
+	Mode CacheMode
+	TTL  time.Duration
+	// Store is set when the command or fetch opted in to caching. Other
+	// requests go straight to the network, so per-account responses never
+	// land on disk by default; CacheOnly still serves what is stored.
+	Store bool
+}
+
+type cacheEntry struct {
+	URL     string      `json:"url"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Expires time.Time   `json:"expires"`
+}
+
+func (c *Cache) do(spec Spec, timeout time.Duration) (*Response, error) {
+	if !c.Store && c.Mode != CacheOnly {
+		return fetch(spec, timeout)
+	}
+	path := filepath.Join(c.Dir, cacheKey(spec)+".json")
+	now := time.Now()
+	var entry *cacheEntry
+	if c.Mode != CacheBypass {
+		entry = loadEntry(path)
+	}
+	if c.Mode == CacheOnly {
+		if entry == nil {
+			return nil, fmt.Errorf("not in cache: %s %s", methodOf(spec), spec.URL)
+		}
+		return entry.response("hit"), nil
+	}
+	if entry != nil && now.Before(entry.Expires) {
+		return entry.response("hit"), nil
+	}
+	if entry != nil {
+		headers := make(map[string]string, len(spec.Headers)+2)
+		for k, v := range spec.Headers {
+			headers[k] = v
+		}
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			headers["If-None-Match"] = etag
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			headers["If-Modified-Since"] = modified
+		}
+		spec.Headers = headers
+	}
+	resp, err := fetch(spec, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == http.StatusNotModified && entry != nil {
+		for _, name := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Date"} {
+			if v := resp.Headers.Values(name); len(v) > 0 {
+				entry.Header[name] = v
+			}
+		}
+		entry.Expires = c.expires(entry.Header, time.Now())
+		_ = saveEntry(path, entry)
+		return entry.response("revalidated"), nil
+	}
+	if resp.Status == http.StatusOK && c.storable(resp.Headers) {
+		entry = &cacheEntry{
+			URL:     spec.URL,
+			Status:  resp.Status,
+			Header:  resp.Headers,
+			Body:    resp.Body,
+			Expires: c.expires(resp.Headers, time.Now()),
+		}
+		_ = saveEntry(path, entry)
+	}
+	if resp.Headers == nil {
+		resp.Headers = http.Header{}
+	}
+	resp.Headers.Set(CacheStatusHeader, "miss")
+	return resp, nil
+}
+
+func (c *Cache) storable(header http.Header) bool {
+	directives := cacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if c.TTL > 0 {
+		return true
+	}
+	if header.Get("Vary") == "*" {
+		return false
+	}
+	if header.Get("ETag") != "" || header.Get("Last-Modified") != "" {
+		return true
+	}
+	return c.expires(header, time.Now()).After(time.Now())
+}
+
+// expires returns when a response stored at now goes stale.
+func (c *Cache) expires(header http.Header, now time.Time) time.Time {
+	directives := cacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return now
+	}
+	if c.TTL > 0 {
+		return now.Add(c.TTL)
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now
+	}
+	if v, ok := directives["max-age"]; ok {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			return now
+		}
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return now.Add(time.Duration(secs-age) * time.Second)
+	}
+	if v := header.Get("Expires"); v != "" {
+		if at, err := http.ParseTime(v); err == nil {
+			return at
+		}
+	}
+	return now
+}
+
+func cacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+func (e *cacheEntry) response(status string) *Response {
+	header := e.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(CacheStatusHeader, status)
+	return NewResponse(e.Status, header, e.Body)
+}
+
+// cacheKey hashes the method, URL, request headers and signing identity,
+// so requests sent with different credentials never share an entry.
+func cacheKey(spec Spec) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", methodOf(spec), spec.URL)
+	names := make([]string, 0, len(spec.Headers))
+	for k := range spec.Headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(h, "%s: %s\n", strings.ToLower(k), spec.Headers[k])
+	}
+	if spec.SigV4 != nil {
+		fmt.Fprintf(h, "sigv4: %s %s %s\n", spec.SigV4.AccessKeyID, spec.SigV4.Region, spec.SigV4.Service)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cacheable(spec Spec) bool {
+	method := methodOf(spec)
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func methodOf(spec Spec) string {
+	if spec.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(spec.Method)
+}
+
+func loadEntry(path string) *cacheEntry {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if json.Unmarshal(b, &entry) != nil {
+		return nil
+	}
+	return &entry
+}
+
+func saveEntry(path string, entry *cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
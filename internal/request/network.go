@@ -0,0 +1,174 @@
+package request
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Network holds the transport settings of a profile:
+//
+//	{"proxy": "http://proxy:3128", "caCerts": ["~/corp-ca.pem"], "clientCert": "~/client.pem",
+//	 "clientKeySecret": "tls_key", "tlsMinVersion": "1.2"}
+type Network struct {
+	// Proxy overrides HTTP_PROXY/HTTPS_PROXY; "direct" disables proxying.
+	Proxy string `json:"proxy,omitempty"`
+	// CACerts are PEM files trusted in addition to the system roots.
+	CACerts []string `json:"caCerts,omitempty"`
+	// ClientCert is the PEM certificate presented for mutual TLS.
+	ClientCert string `json:"clientCert,omitempty"`
+	// ClientKey is the PEM key file for ClientCert.
+	ClientKey string `json:"clientKey,omitempty"`
+	// ClientKeySecret names the secret holding the PEM key instead of
+	// ClientKey.
+	ClientKeySecret string `json:"clientKeySecret,omitempty"`
+	// TLSMinVersion is 1.0, 1.1, 1.2 or 1.3.
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+}
+
+// NetworkKeys are the settings accepted by Set, in display order.
+var NetworkKeys = []string{"proxy", "caCerts", "clientCert", "clientKey", "clientKeySecret", "tlsMinVersion"}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (n *Network) IsZero() bool {
+	return n == nil || (n.Proxy == "" && len(n.CACerts) == 0 && n.ClientCert == "" &&
+		n.ClientKey == "" && n.ClientKeySecret == "" && n.TLSMinVersion == "")
+}
+
+// Set updates one setting by its JSON name; an empty value clears it.
+// caCerts takes a comma-separated list of files.
+func (n *Network) Set(key, value string) error {
+	switch key {
+	case "proxy":
+		if value != "" && value != "direct" {
+			if _, err := url.Parse(value); err != nil {
+				return fmt.Errorf("invalid proxy: %w", err)
+			}
+		}
+		n.Proxy = value
+	case "caCerts":
+		n.CACerts = nil
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				n.CACerts = append(n.CACerts, path)
+			}
+		}
+	case "clientCert":
+		n.ClientCert = value
+	case "clientKey":
+		n.ClientKey = value
+	case "clientKeySecret":
+		n.ClientKeySecret = value
+	case "tlsMinVersion":
+		if _, ok := tlsVersions[value]; value != "" && !ok {
+			return fmt.Errorf("invalid tlsMinVersion %q: want 1.0, 1.1, 1.2 or 1.3", value)
+		}
+		n.TLSMinVersion = value
+	default:
+		return fmt.Errorf("unknown network setting %q (want one of %s)", key, strings.Join(NetworkKeys, ", "))
+	}
+	return nil
+}
+
+// Get returns one setting by its JSON name.
+func (n *Network) Get(key string) string {
+	switch key {
+	case "proxy":
+		return n.Proxy
+	case "caCerts":
+		return strings.Join(n.CACerts, ",")
+	case "clientCert":
+		return n.ClientCert
+	case "clientKey":
+		return n.ClientKey
+	case "clientKeySecret":
+		return n.ClientKeySecret
+	case "tlsMinVersion":
+		return n.TLSMinVersion
+	}
+	return ""
+}
+
+// Transport builds a transport for n. keyPEM is the client key loaded from
+// the secret store when ClientKeySecret is set.
+func (n *Network) Transport(keyPEM []byte) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	switch n.Proxy {
+	case "":
+	case "direct":
+		transport.Proxy = nil
+	default:
+		proxy, err := url.Parse(n.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig := &tls.Config{}
+	if n.TLSMinVersion != "" {
+		version, ok := tlsVersions[n.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid tlsMinVersion %q", n.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+	if len(n.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, path := range n.CACerts {
+			pem, err := os.ReadFile(expandHome(path))
+			if err != nil {
+				return nil, fmt.Errorf("ca cert: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ca cert %s: no PEM certificates found", path)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if n.ClientCert != "" {
+		certPEM, err := os.ReadFile(expandHome(n.ClientCert))
+		if err != nil {
+			return nil, fmt.Errorf("client cert: %w", err)
+		}
+		if keyPEM == nil {
+			if n.ClientKey == "" {
+				return nil, errors.New("client cert needs clientKey or clientKeySecret")
+			}
+			if keyPEM, err = os.ReadFile(expandHome(n.ClientKey)); err != nil {
+				return nil, fmt.Errorf("client key: %w", err)
+			}
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("client cert: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -1,19 +1,60 @@
 package request
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type Spec struct {
-	Method  string            `json:"method"`
-	URL     string            `json:"url"`
-	Headers map[string]string `json:"headers"`
-	Body    any               `json:"body"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Body      any               `json:"body"`
+	Retry     *RetryPolicy      `json:"retry,omitempty"`
+	Limiter   Limiter           `json:"-"`
+	Cache     *Cache            `json:"-"`
+	Transport http.RoundTripper `json:"-"`
+	SigV4     *SigV4            `json:"-"`
+}
+
+// Limiter throttles outgoing requests. Wait is called before every attempt
+// and Observe with the status and headers of every response.
+type Limiter interface {
+	Wait() error
+	Observe(status int, header http.Header)
+}
+
+// Multipart is a multipart/form-data request body. A url.Values body is
+// sent as application/x-www-form-urlencoded instead.
+type Multipart struct {
+	Parts []Part
+}
+
+// Part is a single form field. File parts set Filename and carry their
+// content either in Data or in a local Path read when the request is sent.
+type Part struct {
+	Name        string
+	Value       string
+	Filename    string
+	ContentType string
+	Data        []byte
+	Path        string
 }
 
 type Response struct {
@@ -23,60 +64,338 @@ type Response struct {
 	ParsedJSON any
 }
 
+// IsText reports whether the response body is text. It goes by the
+// Content-Type header and falls back to checking for valid UTF-8.
+func (r *Response) IsText() bool {
+	contentType := r.Headers.Get("Content-Type")
+	if contentType == "" {
+		return utf8.Valid(r.Body)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded", "application/x-ndjson", "application/graphql":
+		return true
+	}
+	return params["charset"] != ""
+}
+
+// Event is a single Server-Sent Event read from a streaming response.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry int
+}
+
+// ErrStopStream can be returned from a stream callback to stop reading
+// events without treating the early exit as a failure.
+var ErrStopStream = errors.New("stop stream")
+
+// Do sends the request and reads the whole response. GET and HEAD requests
+// go through spec.Cache when one is set.
 func Do(spec Spec, timeout time.Duration) (*Response, error) {
+	if spec.Cache != nil && cacheable(spec) {
+		return spec.Cache.do(spec, timeout)
+	}
+	return fetch(spec, timeout)
+}
+
+func fetch(spec Spec, timeout time.Duration) (*Response, error) {
+	resp, err := sendWithRetry(spec, timeout, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readResponse(resp)
+}
+
+// Stream sends the request and invokes onEvent for each Server-Sent Event as
+// it arrives. Responses that are not event streams (including error statuses)
+// are read in full and returned like Do. timeout bounds the wait for the
+// response headers and for each read after that, not the whole stream.
+func Stream(spec Spec, timeout time.Duration, onEvent func(Event) error) (*Response, error) {
+	spec.Headers = WithDefaultHeader(spec.Headers, "Accept", "text/event-stream")
+	resp, err := sendWithRetry(spec, timeout, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return readResponse(resp)
+	}
+	if err := ReadEvents(resp.Body, onEvent); err != nil && !errors.Is(err, ErrStopStream) {
+		return nil, err
+	}
+	return &Response{
+		Status:  resp.StatusCode,
+		Headers: resp.Header,
+	}, nil
+}
+
+func send(spec Spec, timeout time.Duration, stream bool) (*http.Response, error) {
+	req, _, err := NewRequest(spec)
+	if err != nil {
+		return nil, err
+	}
+	if !stream || timeout <= 0 {
+		client := &http.Client{Timeout: timeout, Transport: spec.Transport}
+		return client.Do(req)
+	}
+	// A client timeout would cover reading the whole body and cut long
+	// streams off, so the stream is cancelled when it goes quiet instead.
+	ctx, cancel := context.WithCancelCause(req.Context())
+	idle := &idleBody{ctx: ctx, cancel: cancel, timeout: timeout}
+	idle.timer = time.AfterFunc(timeout, idle.expire)
+	client := &http.Client{Transport: spec.Transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	idle.timer.Stop()
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && errors.Is(context.Cause(ctx), errStreamIdle) {
+			urlErr.Err = fmt.Errorf("no response headers after %s", timeout)
+		}
+		cancel(nil)
+		return nil, err
+	}
+	idle.ReadCloser = resp.Body
+	resp.Body = idle
+	return resp, nil
+}
+
+// errStreamIdle cancels a stream that has gone quiet for too long.
+var errStreamIdle = errors.New("stream idle")
+
+// idleBody is a streamed response body that is cancelled when a read waits
+// longer than timeout.
+type idleBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func (b *idleBody) expire() {
+	b.cancel(errStreamIdle)
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && errors.Is(context.Cause(b.ctx), errStreamIdle) {
+		err = fmt.Errorf("stream sent no data for %s", b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	b.cancel(nil)
+	return b.ReadCloser.Close()
+}
+
+// WithDefaultHeader returns a copy of headers with name set to value, unless
+// headers already have name in any case.
+func WithDefaultHeader(headers map[string]string, name, value string) map[string]string {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return headers
+		}
+	}
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
+
+// NewRequest builds the request spec describes, encoding the body and
+// signing it when spec.SigV4 is set. It also returns the encoded body.
+func NewRequest(spec Spec) (*http.Request, []byte, error) {
 	if spec.URL == "" {
-		return nil, errors.New("request url is empty")
+		return nil, nil, errors.New("request url is empty")
 	}
 	method := spec.Method
 	if method == "" {
 		method = http.MethodGet
 	}
-	var body io.Reader
+	var payload []byte
+	var boundaryType string
 	if spec.Body != nil {
 		switch v := spec.Body.(type) {
 		case string:
-			body = bytes.NewBufferString(v)
+			payload = []byte(v)
 		case []byte:
-			body = bytes.NewBuffer(v)
+			payload = v
+		case url.Values:
+			payload = []byte(v.Encode())
+			spec.Headers = WithDefaultHeader(spec.Headers, "Content-Type", "application/x-www-form-urlencoded")
+		case *Multipart:
+			encoded, contentType, err := v.encode()
+			if err != nil {
+				return nil, nil, err
+			}
+			payload = encoded
+			boundaryType = contentType
 		default:
 			encoded, err := json.Marshal(v)
 			if err != nil {
-				return nil, err
-			}
-			body = bytes.NewBuffer(encoded)
-			if spec.Headers == nil {
-				spec.Headers = map[string]string{}
-			}
-			if _, ok := spec.Headers["Content-Type"]; !ok {
-				spec.Headers["Content-Type"] = "application/json"
+				return nil, nil, err
 			}
+			payload = encoded
+			spec.Headers = WithDefaultHeader(spec.Headers, "Content-Type", "application/json")
 		}
 	}
+	var body io.Reader
+	if spec.Body != nil {
+		body = bytes.NewReader(payload)
+	}
 	req, err := http.NewRequest(method, spec.URL, body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for k, v := range spec.Headers {
 		req.Header.Set(k, v)
 	}
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if boundaryType != "" {
+		// The boundary must match the body, so any caller value is replaced.
+		req.Header.Set("Content-Type", boundaryType)
 	}
-	defer resp.Body.Close()
+	if spec.SigV4 != nil {
+		spec.SigV4.sign(req, payload, time.Now())
+	}
+	return req, payload, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// encode writes the parts and returns the body with its Content-Type,
+// which carries the generated boundary.
+func (m *Multipart) encode() ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, part := range m.Parts {
+		if part.Filename == "" && part.Path == "" && part.Data == nil {
+			if err := w.WriteField(part.Name, part.Value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		data := part.Data
+		filename := part.Filename
+		if part.Path != "" {
+			b, err := os.ReadFile(part.Path)
+			if err != nil {
+				return nil, "", fmt.Errorf("form file %s: %w", part.Name, err)
+			}
+			data = b
+			if filename == "" {
+				filename = filepath.Base(part.Path)
+			}
+		}
+		contentType := part.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(part.Name), quoteEscaper.Replace(filename)))
+		header.Set("Content-Type", contentType)
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := pw.Write(data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+func readResponse(resp *http.Response) (*Response, error) {
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	return NewResponse(resp.StatusCode, resp.Header, b), nil
+}
+
+// NewResponse builds a Response, parsing the body when it is JSON.
+func NewResponse(status int, header http.Header, body []byte) *Response {
 	var parsed any
-	if len(b) > 0 {
-		_ = json.Unmarshal(b, &parsed)
+	if len(body) > 0 {
+		_ = json.Unmarshal(body, &parsed)
 	}
 	return &Response{
-		Status:     resp.StatusCode,
-		Headers:    resp.Header,
-		Body:       b,
+		Status:     status,
+		Headers:    header,
+		Body:       body,
 		ParsedJSON: parsed,
-	}, nil
+	}
+}
+
+// ReadEvents parses a Server-Sent Events stream, calling onEvent for each
+// event.
+func ReadEvents(r io.Reader, onEvent func(Event) error) error {
+	reader := bufio.NewReader(r)
+	var ev Event
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		eof := errors.Is(err, io.EOF)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				if err := onEvent(ev); err != nil {
+					return err
+				}
+			}
+			ev = Event{ID: ev.ID}
+			data = data[:0]
+			if eof {
+				return nil
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "data":
+				data = append(data, value)
+			case "event":
+				ev.Event = value
+			case "id":
+				ev.ID = value
+			case "retry":
+				if n, err := strconv.Atoi(value); err == nil {
+					ev.Retry = n
+				}
+			}
+		}
+		if eof {
+			if len(data) > 0 {
+				ev.Data = strings.Join(data, "\n")
+				return onEvent(ev)
+			}
+			return nil
+		}
+	}
 }
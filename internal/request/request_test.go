@@ -0,0 +1,600 @@
+package request
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("expected event-stream accept header, got %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(": keepalive\n\nevent: delta\nid: 1\ndata: {\"n\":1}\n\ndata: line one\r\ndata: line two\r\n\r\ndata: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	var events []Event
+	resp, err := Stream(Spec{URL: server.URL}, 5*time.Second, func(ev Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if resp.Status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Status)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].Event != "delta" || events[0].ID != "1" || events[0].Data != `{"n":1}` {
+		t.Fatalf("unexpected first event: %+v", events[0])
+	}
+	if events[1].Data != "line one\nline two" {
+		t.Fatalf("expected multi-line data, got %q", events[1].Data)
+	}
+	if events[2].Data != "[DONE]" {
+		t.Fatalf("expected [DONE], got %q", events[2].Data)
+	}
+}
+
+func TestStreamStopAndFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			if got := r.Header.Values("Accept"); len(got) != 1 || got[0] != "application/json" {
+				t.Errorf("expected the caller's accept header, got %q", got)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"slow down"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: a\n\ndata: b\n\ndata: c\n\n"))
+	}))
+	defer server.Close()
+
+	count := 0
+	_, err := Stream(Spec{URL: server.URL}, 5*time.Second, func(ev Event) error {
+		count++
+		if ev.Data == "b" {
+			return ErrStopStream
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected stream to stop after 2 events, got %d", count)
+	}
+
+	headers := map[string]string{"accept": "application/json"}
+	resp, err := Stream(Spec{URL: server.URL + "/error", Headers: headers}, 5*time.Second, func(ev Event) error {
+		t.Fatalf("unexpected event on error response")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if len(headers) != 1 {
+		t.Fatalf("expected caller headers to be left alone, got %v", headers)
+	}
+	if resp.Status != http.StatusTooManyRequests || string(resp.Body) != `{"error":"slow down"}` {
+		t.Fatalf("expected error body to be returned, got %d %s", resp.Status, resp.Body)
+	}
+}
+
+func TestStreamTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range 6 {
+			if r.URL.Path == "/stall" && i == 2 {
+				time.Sleep(300 * time.Millisecond)
+			}
+			_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	// The stream runs past the timeout but never goes quiet for that long.
+	count := 0
+	_, err := Stream(Spec{URL: server.URL}, 150*time.Millisecond, func(ev Event) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 6 {
+		t.Fatalf("expected all 6 events, got %d (%v)", count, err)
+	}
+
+	_, err = Stream(Spec{URL: server.URL + "/stall"}, 150*time.Millisecond, func(ev Event) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "stream sent no data for 150ms") {
+		t.Fatalf("expected idle stream error, got %v", err)
+	}
+	_, err = Stream(Spec{URL: server.URL + "/slow"}, 150*time.Millisecond, func(ev Event) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "no response headers after 150ms") {
+		t.Fatalf("expected header timeout error, got %v", err)
+	}
+}
+
+func TestResponseIsText(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        []byte
+		want        bool
+	}{
+		{"application/json; charset=utf-8", []byte(`{}`), true},
+		{"text/plain", []byte("hi"), true},
+		{"application/problem+json", []byte(`{}`), true},
+		{"image/png", []byte{0x89, 'P', 'N', 'G'}, false},
+		{"application/octet-stream", []byte("abc"), false},
+		{"application/pdf", []byte("%PDF"), false},
+		{"", []byte("plain"), true},
+		{"", []byte{0xff, 0xfe, 0x00}, false},
+	}
+	for _, tc := range cases {
+		resp := &Response{Headers: http.Header{}, Body: tc.body}
+		if tc.contentType != "" {
+			resp.Headers.Set("Content-Type", tc.contentType)
+		}
+		if got := resp.IsText(); got != tc.want {
+			t.Fatalf("IsText(%q) = %v, want %v", tc.contentType, got, tc.want)
+		}
+	}
+}
+
+func TestDoFormBodies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.json")
+	if err := os.WriteFile(path, []byte(`{"a":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/form" {
+			if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+				t.Errorf("unexpected form content type %q", r.Header.Get("Content-Type"))
+			}
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(body)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("ParseMultipartForm: %v", err)
+			return
+		}
+		if r.FormValue("model") != "flux" {
+			t.Errorf("expected model field, got %q", r.FormValue("model"))
+		}
+		for name, want := range map[string]string{"input": `{"a":1}`, "raw": "\x00\x01"} {
+			f, header, err := r.FormFile(name)
+			if err != nil {
+				t.Errorf("FormFile %s: %v", name, err)
+				continue
+			}
+			b, _ := io.ReadAll(f)
+			f.Close()
+			if string(b) != want {
+				t.Errorf("%s: expected %q, got %q", name, want, b)
+			}
+			if name == "input" && (header.Filename != "input.json" || header.Header.Get("Content-Type") != "application/json") {
+				t.Errorf("unexpected file header %+v", header.Header)
+			}
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := Do(Spec{
+		Method: http.MethodPost,
+		URL:    server.URL + "/form",
+		Body:   url.Values{"grant_type": {"client_credentials"}, "scope": {"read write"}},
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if string(resp.Body) != "grant_type=client_credentials&scope=read+write" {
+		t.Fatalf("unexpected form body %q", resp.Body)
+	}
+
+	resp, err = Do(Spec{
+		Method:  http.MethodPost,
+		URL:     server.URL + "/upload",
+		Headers: map[string]string{"content-type": "multipart/form-data"},
+		Body: &Multipart{Parts: []Part{
+			{Name: "model", Value: "flux"},
+			{Name: "input", Path: path},
+			{Name: "raw", Filename: "raw.bin", Data: []byte{0, 1}},
+		}},
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if string(resp.Body) != "ok" {
+		t.Fatalf("unexpected upload response %q", resp.Body)
+	}
+
+	_, err = Do(Spec{
+		Method: http.MethodPost,
+		URL:    server.URL + "/upload",
+		Body:   &Multipart{Parts: []Part{{Name: "input", Path: filepath.Join(dir, "missing.json")}}},
+	}, 5*time.Second)
+	if err == nil {
+		t.Fatal("expected error for missing form file")
+	}
+
+	// A caller's Content-Type wins whatever its case, without touching
+	// their headers. Map order decides the winner otherwise, so try a few.
+	for _, body := range []any{url.Values{"a": {"1"}}, map[string]any{"a": 1}} {
+		for range 20 {
+			headers := map[string]string{"content-type": "text/plain"}
+			req, _, err := NewRequest(Spec{Method: http.MethodPost, URL: server.URL, Headers: headers, Body: body})
+			if err != nil {
+				t.Fatalf("NewRequest error: %v", err)
+			}
+			if got := req.Header.Values("Content-Type"); len(got) != 1 || got[0] != "text/plain" || len(headers) != 1 {
+				t.Fatalf("%T: expected the caller's content type, got %q (headers %v)", body, got, headers)
+			}
+		}
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.Method+r.URL.Path]++
+		switch {
+		case r.URL.Path == "/flaky" && calls[r.Method+r.URL.Path] < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/later":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	var retries []Retry
+	policy := &RetryPolicy{Attempts: 3, Delay: time.Millisecond, OnRetry: func(r Retry) { retries = append(retries, r) }}
+	resp, err := Do(Spec{URL: server.URL + "/flaky", Retry: policy}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if resp.Status != http.StatusOK || calls["GET/flaky"] != 3 {
+		t.Fatalf("expected success on third attempt, got %d after %d calls", resp.Status, calls["GET/flaky"])
+	}
+	if len(retries) != 2 || retries[0].Attempt != 2 || retries[0].Status != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected retries %+v", retries)
+	}
+
+	resp, _ = Do(Spec{Method: http.MethodPost, URL: server.URL + "/flaky", Retry: policy}, 5*time.Second)
+	if resp.Status != http.StatusServiceUnavailable || calls["POST/flaky"] != 1 {
+		t.Fatalf("expected POST not to be retried, got %d after %d calls", resp.Status, calls["POST/flaky"])
+	}
+	unsafe := policy.Merge(&RetryPolicy{Unsafe: true})
+	resp, _ = Do(Spec{Method: http.MethodPost, URL: server.URL + "/flaky", Retry: unsafe}, 5*time.Second)
+	if resp.Status != http.StatusOK || calls["POST/flaky"] != 3 {
+		t.Fatalf("expected unsafe POST to be retried, got %d after %d calls", resp.Status, calls["POST/flaky"])
+	}
+
+	resp, _ = Do(Spec{URL: server.URL + "/missing", Retry: policy}, 5*time.Second)
+	if resp.Status != http.StatusNotFound || calls["GET/missing"] != 1 {
+		t.Fatalf("expected 404 not to be retried, got %d calls", calls["GET/missing"])
+	}
+
+	// A Retry-After beyond MaxDelay is honored by giving up rather than waiting.
+	resp, _ = Do(Spec{URL: server.URL + "/later", Retry: policy}, 5*time.Second)
+	if resp.Status != http.StatusTooManyRequests || calls["GET/later"] != 1 {
+		t.Fatalf("expected no retry past max delay, got %d calls", calls["GET/later"])
+	}
+}
+
+func TestRetryPolicyJSON(t *testing.T) {
+	var spec Spec
+	if err := json.Unmarshal([]byte(`{"url":"x","retry":5}`), &spec); err != nil {
+		t.Fatalf("unmarshal number: %v", err)
+	}
+	if spec.Retry.Attempts != 5 {
+		t.Fatalf("expected 5 attempts, got %+v", spec.Retry)
+	}
+	var policy RetryPolicy
+	if err := json.Unmarshal([]byte(`{"delay":250,"maxDelay":2000,"statuses":[503],"unsafe":true}`), &policy); err != nil {
+		t.Fatalf("unmarshal object: %v", err)
+	}
+	if policy.Attempts != defaultRetryAttempts || policy.Delay != 250*time.Millisecond || policy.MaxDelay != 2*time.Second || !policy.Unsafe || len(policy.Statuses) != 1 {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	b, err := json.Marshal(policy)
+	if err != nil || string(b) != `{"attempts":3,"delay":250,"maxDelay":2000,"statuses":[503],"unsafe":true}` {
+		t.Fatalf("unexpected marshal %s (%v)", b, err)
+	}
+	if err := json.Unmarshal([]byte(`false`), &policy); err != nil || policy.Attempts != 1 {
+		t.Fatalf("expected false to disable retries, got %+v (%v)", policy, err)
+	}
+	if err := json.Unmarshal([]byte(`-1`), &policy); err == nil {
+		t.Fatal("expected error for negative attempts")
+	}
+
+	merged := (&RetryPolicy{Attempts: 4, Delay: time.Second}).Merge(&RetryPolicy{Attempts: 1})
+	if merged.Attempts != 1 || merged.Delay != time.Second {
+		t.Fatalf("unexpected merge %+v", merged)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if d, ok := ParseRetryAfter("7", now); !ok || d != 7*time.Second {
+		t.Fatalf("expected 7s, got %v %v", d, ok)
+	}
+	if d, ok := ParseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now); !ok || d != 90*time.Second {
+		t.Fatalf("expected 90s, got %v %v", d, ok)
+	}
+	if _, ok := ParseRetryAfter("soon", now); ok {
+		t.Fatal("expected invalid Retry-After to be ignored")
+	}
+	policy := &RetryPolicy{Delay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt <= 6; attempt++ {
+		d := policy.backoff(attempt)
+		if d < 50*time.Millisecond || d > time.Second {
+			t.Fatalf("attempt %d: backoff %v out of range", attempt, d)
+		}
+	}
+}
+
+func TestDoCache(t *testing.T) {
+	calls := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Cache-Control", "no-cache")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Vary", "*")
+		}
+		_, _ = w.Write([]byte("body " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	cache := &Cache{Dir: t.TempDir(), Store: true}
+	get := func(c *Cache, path string) *Response {
+		t.Helper()
+		resp, err := Do(Spec{URL: server.URL + path, Cache: c}, 5*time.Second)
+		if err != nil {
+			t.Fatalf("Do %s error: %v", path, err)
+		}
+		return resp
+	}
+
+	if resp := get(cache, "/fresh"); resp.Headers.Get(CacheStatusHeader) != "miss" {
+		t.Fatalf("expected first request to miss, got %q", resp.Headers.Get(CacheStatusHeader))
+	}
+	resp := get(cache, "/fresh")
+	if resp.Headers.Get(CacheStatusHeader) != "hit" || string(resp.Body) != "body /fresh" || calls["/fresh"] != 1 {
+		t.Fatalf("expected cached hit, got %q %q after %d calls", resp.Headers.Get(CacheStatusHeader), resp.Body, calls["/fresh"])
+	}
+
+	get(cache, "/etag")
+	resp = get(cache, "/etag")
+	if resp.Headers.Get(CacheStatusHeader) != "revalidated" || resp.Status != http.StatusOK || string(resp.Body) != "body /etag" || calls["/etag"] != 2 {
+		t.Fatalf("expected revalidated 200, got %q %d %q after %d calls", resp.Headers.Get(CacheStatusHeader), resp.Status, resp.Body, calls["/etag"])
+	}
+
+	get(cache, "/private")
+	get(cache, "/private")
+	if calls["/private"] != 2 {
+		t.Fatalf("expected no-store response not to be cached, got %d calls", calls["/private"])
+	}
+
+	get(&Cache{Dir: cache.Dir, TTL: time.Minute, Store: true}, "/plain")
+	get(&Cache{Dir: cache.Dir, TTL: time.Minute, Store: true}, "/plain")
+	if calls["/plain"] != 1 {
+		t.Fatalf("expected TTL to cache the response, got %d calls", calls["/plain"])
+	}
+
+	get(&Cache{Dir: cache.Dir, Mode: CacheBypass, Store: true}, "/fresh")
+	if calls["/fresh"] != 2 {
+		t.Fatalf("expected bypass to fetch, got %d calls", calls["/fresh"])
+	}
+
+	get(cache, "/vary")
+	get(cache, "/vary")
+	if calls["/vary"] != 2 {
+		t.Fatalf("expected Vary: * response not to be cached, got %d calls", calls["/vary"])
+	}
+
+	// Requests that did not opt in are never stored, even with an ETag.
+	unopted := t.TempDir()
+	get(&Cache{Dir: unopted}, "/etag")
+	if entries, _ := os.ReadDir(unopted); len(entries) != 0 || calls["/etag"] != 3 {
+		t.Fatalf("expected un-opted response not to be stored, got %d entries after %d calls", len(entries), calls["/etag"])
+	}
+
+	only := &Cache{Dir: cache.Dir, Mode: CacheOnly}
+	if resp := get(only, "/etag"); resp.Headers.Get(CacheStatusHeader) != "hit" {
+		t.Fatalf("expected cache-only hit for stale entry, got %q", resp.Headers.Get(CacheStatusHeader))
+	}
+	if _, err := Do(Spec{URL: server.URL + "/other", Cache: only}, 5*time.Second); err == nil || !strings.Contains(err.Error(), "not in cache") {
+		t.Fatalf("expected cache-only miss error, got %v", err)
+	}
+	if calls["/other"] != 0 {
+		t.Fatalf("expected cache-only not to touch the network")
+	}
+
+	// Different credentials never share an entry.
+	resp, _ = Do(Spec{URL: server.URL + "/fresh", Headers: map[string]string{"Authorization": "Bearer other"}, Cache: cache}, 5*time.Second)
+	if resp.Headers.Get(CacheStatusHeader) != "miss" {
+		t.Fatalf("expected other credentials to miss, got %q", resp.Headers.Get(CacheStatusHeader))
+	}
+}
+
+func TestNetworkTransport(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPath := filepath.Join(dir, "client.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	_ = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caPath := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	if _, err := Do(Spec{URL: server.URL}, 5*time.Second); err == nil {
+		t.Fatalf("expected untrusted server certificate to fail")
+	}
+	network := &Network{CACerts: []string{caPath}, TLSMinVersion: "1.2"}
+	transport, err := network.Transport(nil)
+	if err != nil {
+		t.Fatalf("Transport error: %v", err)
+	}
+	if _, err := Do(Spec{URL: server.URL, Transport: transport}, 5*time.Second); err == nil {
+		t.Fatalf("expected request without client certificate to fail")
+	}
+	network.ClientCert = certPath
+	transport, err = network.Transport(keyPEM)
+	if err != nil {
+		t.Fatalf("Transport error: %v", err)
+	}
+	resp, err := Do(Spec{URL: server.URL, Transport: transport}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if string(resp.Body) != "hello client" {
+		t.Fatalf("unexpected body %q", resp.Body)
+	}
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+	transport, err = (&Network{Proxy: proxy.URL}).Transport(nil)
+	if err != nil {
+		t.Fatalf("Transport error: %v", err)
+	}
+	resp, err = Do(Spec{URL: "http://internal.example/v1/items", Transport: transport}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if string(resp.Body) != "via proxy" || proxied != "http://internal.example/v1/items" {
+		t.Fatalf("expected request through proxy, got %q for %q", resp.Body, proxied)
+	}
+}
+
+func TestSigV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite.
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signer := &SigV4{Region: "us-east-1", Service: "service", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signer.sign(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("unexpected signature\n got %s\nwant %s", got, want)
+	}
+
+	// Keys sort before values, so a key that prefixes another comes first.
+	query := url.Values{"a-b": {"1"}, "a": {"2", "1"}, "b": {"x y"}}
+	if got, want := canonicalQuery(query), "a=1&a=2&a-b=1&b=x%20y"; got != want {
+		t.Fatalf("unexpected canonical query\n got %s\nwant %s", got, want)
+	}
+
+	// An S3-style stand-in that re-signs what it receives and compares.
+	s3 := &SigV4{Region: "us-east-1", Service: "s3", AccessKeyID: "minio", SecretAccessKey: "minio-secret", SessionToken: "session"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) || r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		at, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		check := r.Clone(r.Context())
+		check.URL.Host = r.Host
+		check.Header = http.Header{"Content-Type": r.Header.Values("Content-Type")}
+		s3.sign(check, body, at)
+		if check.Header.Get("Authorization") != r.Header.Get("Authorization") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("stored " + r.URL.EscapedPath()))
+	}))
+	defer server.Close()
+
+	resp, err := Do(Spec{
+		Method:  http.MethodPut,
+		URL:     server.URL + "/bucket/my file+1.txt?x-id=PutObject",
+		Headers: map[string]string{"Content-Type": "text/plain"},
+		Body:    "hello",
+		SigV4:   s3,
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Do error: %v", err)
+	}
+	if resp.Status != http.StatusOK || string(resp.Body) != "stored /bucket/my%20file%2B1.txt" {
+		t.Fatalf("expected signed upload to be accepted, got %d %q", resp.Status, resp.Body)
+	}
+}
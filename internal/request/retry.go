@@ -0,0 +1,266 @@
+package request
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. The zero value
+// makes a single attempt. In JSON it is either a number of attempts, a
+// boolean, or an object with delays in milliseconds:
+//
+//	{"attempts": 4, "delay": 500, "maxDelay": 30000, "statuses": [429, 503], "unsafe": true}
+type RetryPolicy struct {
+	// Attempts is the total number of attempts, including the first.
+	Attempts int
+	// Delay is the base backoff, doubled after each attempt.
+	Delay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than MaxDelay ends
+	// the retries instead of waiting.
+	MaxDelay time.Duration
+	// Statuses are the response codes worth retrying.
+	Statuses []int
+	// Unsafe allows retrying methods that are not idempotent, like POST.
+	Unsafe bool
+	// OnRetry is called before waiting for each retry.
+	OnRetry func(Retry)
+}
+
+// Retry describes a retry about to happen.
+type Retry struct {
+	Attempt  int
+	Attempts int
+	Delay    time.Duration
+	Status   int
+	Err      error
+}
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 500 * time.Millisecond
+	defaultRetryMaxDelay = 30 * time.Second
+)
+
+var defaultRetryStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+type retryJSON struct {
+	Attempts int   `json:"attempts,omitempty"`
+	Delay    int64 `json:"delay,omitempty"`
+	MaxDelay int64 `json:"maxDelay,omitempty"`
+	Statuses []int `json:"statuses,omitempty"`
+	Unsafe   bool  `json:"unsafe,omitempty"`
+}
+
+func (p *RetryPolicy) UnmarshalJSON(b []byte) error {
+	var raw any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case nil:
+		*p = RetryPolicy{}
+		return nil
+	case bool:
+		*p = RetryPolicy{Attempts: 1}
+		if v {
+			p.Attempts = defaultRetryAttempts
+		}
+		return nil
+	case float64:
+		if v < 1 || v != float64(int(v)) {
+			return errors.New("retry attempts must be a positive integer")
+		}
+		*p = RetryPolicy{Attempts: int(v)}
+		return nil
+	}
+	var r retryJSON
+	if err := json.Unmarshal(b, &r); err != nil {
+		return err
+	}
+	if r.Attempts < 0 || r.Delay < 0 || r.MaxDelay < 0 {
+		return errors.New("retry values must not be negative")
+	}
+	*p = RetryPolicy{
+		Attempts: r.Attempts,
+		Delay:    time.Duration(r.Delay) * time.Millisecond,
+		MaxDelay: time.Duration(r.MaxDelay) * time.Millisecond,
+		Statuses: r.Statuses,
+		Unsafe:   r.Unsafe,
+	}
+	if p.Attempts == 0 {
+		p.Attempts = defaultRetryAttempts
+	}
+	return nil
+}
+
+func (p RetryPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(retryJSON{
+		Attempts: p.Attempts,
+		Delay:    p.Delay.Milliseconds(),
+		MaxDelay: p.MaxDelay.Milliseconds(),
+		Statuses: p.Statuses,
+		Unsafe:   p.Unsafe,
+	})
+}
+
+// Merge returns p with the fields set in override taking precedence.
+func (p *RetryPolicy) Merge(override *RetryPolicy) *RetryPolicy {
+	if p == nil && override == nil {
+		return nil
+	}
+	out := RetryPolicy{}
+	if p != nil {
+		out = *p
+	}
+	if override == nil {
+		return &out
+	}
+	if override.Attempts != 0 {
+		out.Attempts = override.Attempts
+	}
+	if override.Delay != 0 {
+		out.Delay = override.Delay
+	}
+	if override.MaxDelay != 0 {
+		out.MaxDelay = override.MaxDelay
+	}
+	if override.Statuses != nil {
+		out.Statuses = override.Statuses
+	}
+	if override.Unsafe {
+		out.Unsafe = true
+	}
+	if override.OnRetry != nil {
+		out.OnRetry = override.OnRetry
+	}
+	return &out
+}
+
+// sendWithRetry sends spec, retrying according to spec.Retry. Every
+// attempt waits for spec.Limiter when one is set.
+func sendWithRetry(spec Spec, timeout time.Duration, stream bool) (*http.Response, error) {
+	policy := spec.Retry
+	if policy == nil || policy.Attempts <= 1 || !policy.allows(spec.Method) {
+		return sendLimited(spec, timeout, stream)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := sendLimited(spec, timeout, stream)
+		if attempt >= policy.Attempts {
+			return resp, err
+		}
+		retry := Retry{Attempt: attempt + 1, Attempts: policy.Attempts, Err: err}
+		if err == nil {
+			if !policy.retryable(resp.StatusCode) {
+				return resp, nil
+			}
+			retry.Status = resp.StatusCode
+		}
+		delay := policy.backoff(attempt)
+		if err == nil {
+			if after, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if after > policy.maxDelay() {
+					return resp, nil
+				}
+				delay = max(delay, after)
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		retry.Delay = delay
+		if policy.OnRetry != nil {
+			policy.OnRetry(retry)
+		}
+		time.Sleep(delay)
+	}
+}
+
+func sendLimited(spec Spec, timeout time.Duration, stream bool) (*http.Response, error) {
+	if spec.Limiter == nil {
+		return send(spec, timeout, stream)
+	}
+	if err := spec.Limiter.Wait(); err != nil {
+		return nil, fmt.Errorf("rate limit: %w", err)
+	}
+	resp, err := send(spec, timeout, stream)
+	if err == nil {
+		spec.Limiter.Observe(resp.StatusCode, resp.Header)
+	}
+	return resp, err
+}
+
+func (p *RetryPolicy) allows(method string) bool {
+	if p.Unsafe {
+		return true
+	}
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryable(status int) bool {
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+	return slices.Contains(statuses, status)
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+	return defaultRetryMaxDelay
+}
+
+// backoff returns the exponential delay before the attempt after the given
+// one, with the upper half jittered.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.Delay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for i := 1; i < attempt && delay < p.maxDelay(); i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.maxDelay())
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// ParseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
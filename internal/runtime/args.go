@@ -0,0 +1,333 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	quickjs "github.com/buke/quickjs-go"
+)
+
+// Supported arg types. Params arrive from the CLI as strings and are coerced
+// to the declared type before run() is called.
+const (
+	ArgString  = "string"
+	ArgNumber  = "number"
+	ArgInteger = "integer"
+	ArgBoolean = "boolean"
+	ArgJSON    = "json"
+	ArgArray   = "array"
+)
+
+// ArgDoc describes a single command argument. Commands may declare args as
+// plain names ("symbol") or as objects ({ name, desc, type, required, ... }).
+type ArgDoc struct {
+	Name     string `json:"name"`
+	Desc     string `json:"desc,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	Default  any    `json:"default,omitempty"`
+	Enum     []any  `json:"enum,omitempty"`
+	Example  any    `json:"example,omitempty"`
+}
+
+func describeCommand(entry *quickjs.Value, name string) (CommandDoc, error) {
+	doc := CommandDoc{Name: name}
+	descVal := entry.Get("desc")
+	defer descVal.Free()
+	if !descVal.IsUndefined() && !descVal.IsNull() {
+		doc.Desc = descVal.ToString()
+	}
+	pagVal := entry.Get("pagination")
+	defer pagVal.Free()
+	pagination, err := paginationFromValue(pagVal)
+	if err != nil {
+		return doc, err
+	}
+	doc.Pagination = pagination
+	if doc.DefaultQuery, err = defaultQueryFromValue(entry); err != nil {
+		return doc, err
+	}
+	if doc.Columns, err = columnsFromValue(entry); err != nil {
+		return doc, err
+	}
+	argsVal := entry.Get("args")
+	defer argsVal.Free()
+	if !argsVal.IsArray() {
+		return doc, nil
+	}
+	length := argsVal.Get("length")
+	defer length.Free()
+	for i := int64(0); i < length.ToInt64(); i++ {
+		item := argsVal.GetIdx(i)
+		arg, err := parseArg(item)
+		item.Free()
+		if err != nil {
+			return doc, fmt.Errorf("args[%d]: %w", i, err)
+		}
+		doc.Args = append(doc.Args, arg)
+	}
+	return doc, nil
+}
+
+// defaultQueryFromValue reads a command's defaultQuery.
+func defaultQueryFromValue(entry *quickjs.Value) (string, error) {
+	val := entry.Get("defaultQuery")
+	defer val.Free()
+	if val.IsUndefined() || val.IsNull() {
+		return "", nil
+	}
+	if !val.IsString() {
+		return "", errors.New("defaultQuery must be a string")
+	}
+	return val.ToString(), nil
+}
+
+// columnsFromValue reads a command's columns.
+func columnsFromValue(entry *quickjs.Value) ([]string, error) {
+	val := entry.Get("columns")
+	defer val.Free()
+	if val.IsUndefined() || val.IsNull() {
+		return nil, nil
+	}
+	var columns []string
+	if !val.IsArray() || json.Unmarshal([]byte(val.JSONStringify()), &columns) != nil {
+		return nil, errors.New("columns must be an array of strings")
+	}
+	return columns, nil
+}
+
+func parseArg(val *quickjs.Value) (ArgDoc, error) {
+	if !val.IsObject() {
+		return ArgDoc{Name: val.ToString()}, nil
+	}
+	var raw struct {
+		ArgDoc
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(val.JSONStringify()), &raw); err != nil {
+		return ArgDoc{}, err
+	}
+	arg := raw.ArgDoc
+	if arg.Desc == "" {
+		arg.Desc = raw.Description
+	}
+	if arg.Name == "" {
+		return ArgDoc{}, errors.New("arg is missing a name")
+	}
+	switch arg.Type {
+	case "", ArgString, ArgNumber, ArgInteger, ArgBoolean, ArgJSON, ArgArray:
+	default:
+		return ArgDoc{}, fmt.Errorf("arg %q has unknown type %q", arg.Name, arg.Type)
+	}
+	if arg.Default != nil {
+		// Defaults reach run() like passed values, so they get the same
+		// coercion and enum check.
+		if arg.Type != "" {
+			val, err := coerceArg(arg.Type, defaultText(arg.Default))
+			if err != nil {
+				return ArgDoc{}, fmt.Errorf("arg %q default %w", arg.Name, err)
+			}
+			arg.Default = val
+		}
+		if len(arg.Enum) > 0 && !inEnum(arg.Enum, arg.Default) {
+			return ArgDoc{}, fmt.Errorf("arg %q default must be one of %s", arg.Name, enumText(arg.Enum))
+		}
+	}
+	return arg, nil
+}
+
+// defaultText turns a declared default back into the text a flag would carry.
+func defaultText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func enumText(enum []any) string {
+	values := make([]string, 0, len(enum))
+	for _, v := range enum {
+		values = append(values, fmt.Sprint(v))
+	}
+	return strings.Join(values, ", ")
+}
+
+// commandParams validates params against the command's declared args and
+// coerces them to their declared types. Commands that do not declare args
+// receive every param unchanged as a string.
+func commandParams(entry *quickjs.Value, command string, params map[string]string) (map[string]any, error) {
+	argsVal := entry.Get("args")
+	declared := argsVal.IsArray()
+	argsVal.Free()
+	out := make(map[string]any, len(params))
+	if !declared {
+		for k, v := range params {
+			out[k] = v
+		}
+		return out, nil
+	}
+	doc, err := describeCommand(entry, command)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(doc.Args))
+	for _, arg := range doc.Args {
+		known[arg.Name] = true
+	}
+	var unknown []string
+	for k, v := range params {
+		if !known[k] {
+			unknown = append(unknown, k)
+			out[k] = v
+		}
+	}
+	strict, err := strictArgs(entry)
+	if err != nil {
+		return nil, err
+	}
+	if strict && len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown arg %q", unknown[0])
+	}
+	for _, arg := range doc.Args {
+		raw, ok := params[arg.Name]
+		if !ok {
+			if arg.Default != nil {
+				out[arg.Name] = arg.Default
+			} else if arg.Required {
+				return nil, fmt.Errorf("missing required arg %q", arg.Name)
+			}
+			continue
+		}
+		val, err := coerceArg(arg.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("arg %q %w", arg.Name, err)
+		}
+		if len(arg.Enum) > 0 && !inEnum(arg.Enum, val) {
+			return nil, fmt.Errorf("arg %q must be one of %s", arg.Name, enumText(arg.Enum))
+		}
+		out[arg.Name] = val
+	}
+	return out, nil
+}
+
+func coerceArg(argType, raw string) (any, error) {
+	switch argType {
+	case ArgNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return n, nil
+	case ArgInteger:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return n, nil
+	case ArgBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return b, nil
+	case ArgJSON:
+		var v any
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("must be valid JSON: %v", err)
+		}
+		return v, nil
+	case ArgArray:
+		trimmed := strings.TrimSpace(raw)
+		if strings.HasPrefix(trimmed, "[") {
+			var v []any
+			if err := json.Unmarshal([]byte(trimmed), &v); err != nil {
+				return nil, fmt.Errorf("must be a JSON array or comma-separated list: %v", err)
+			}
+			return v, nil
+		}
+		out := []any{}
+		for _, part := range strings.Split(raw, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+		return out, nil
+	default:
+		return raw, nil
+	}
+}
+
+// strictArgs reports whether a command rejects params it does not declare:
+// when it sets strict, or else when any arg uses the object form. Lists of
+// plain names have always been documentation only.
+func strictArgs(entry *quickjs.Value) (bool, error) {
+	strictVal := entry.Get("strict")
+	defer strictVal.Free()
+	if !strictVal.IsUndefined() && !strictVal.IsNull() {
+		if !strictVal.IsBool() {
+			return false, errors.New("strict must be a boolean")
+		}
+		return strictVal.ToBool(), nil
+	}
+	argsVal := entry.Get("args")
+	defer argsVal.Free()
+	length := argsVal.Get("length")
+	defer length.Free()
+	for i := int64(0); i < length.ToInt64(); i++ {
+		item := argsVal.GetIdx(i)
+		isObject := item.IsObject()
+		item.Free()
+		if isObject {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// inEnum compares a coerced param with the enum values. Params without a
+// declared type still match numeric and boolean enums by value.
+func inEnum(values []any, val any) bool {
+	for _, v := range values {
+		if enumEqual(v, val) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumEqual(want, got any) bool {
+	switch w := want.(type) {
+	case float64:
+		switch g := got.(type) {
+		case float64:
+			return g == w
+		case int64:
+			return float64(g) == w
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(g), 64)
+			return err == nil && n == w
+		}
+		return false
+	case bool:
+		switch g := got.(type) {
+		case bool:
+			return g == w
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(g))
+			return err == nil && b == w
+		}
+		return false
+	case string:
+		g, ok := got.(string)
+		return ok && g == w
+	}
+	a, errA := json.Marshal(want)
+	b, errB := json.Marshal(got)
+	return errA == nil && errB == nil && string(a) == string(b)
+}
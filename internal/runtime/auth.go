@@ -0,0 +1,170 @@
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/oauth"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// providerAuth attaches the provider's OAuth token to requests, loading and
+// refreshing it on first use.
+type providerAuth struct {
+	config   *oauth.Config
+	provider string
+	profile  string
+	loaded   bool
+	token    *oauth.Token
+	// placeholder, when set, stands in for the token so that captured and
+	// replayed requests need no token request.
+	placeholder string
+	log         *debugLog
+}
+
+// oauthFromValue reads the oauth metadata of a provider's default export,
+// returning nil when it declares none.
+func oauthFromValue(defaultVal *quickjs.Value) (*oauth.Config, error) {
+	val := defaultVal.Get("oauth")
+	defer val.Free()
+	if val.IsUndefined() || val.IsNull() {
+		return nil, nil
+	}
+	var cfg oauth.Config
+	if err := json.Unmarshal([]byte(val.JSONStringify()), &cfg); err != nil {
+		return nil, fmt.Errorf("invalid oauth: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid oauth: %w", err)
+	}
+	return &cfg, nil
+}
+
+// OAuthConfig returns the oauth metadata a provider script declares, or nil.
+func OAuthConfig(script []byte, moduleDir string) (*oauth.Config, error) {
+	var cfg *oauth.Config
+	err := withDefaultExport(script, moduleDir, func(ctx *quickjs.Context, defaultVal *quickjs.Value) error {
+		var err error
+		cfg, err = oauthFromValue(defaultVal)
+		return err
+	})
+	return cfg, err
+}
+
+func (a *providerAuth) authorize(spec *request.Spec) error {
+	if !a.config.Applies(spec.URL) {
+		return nil
+	}
+	for name := range spec.Headers {
+		if strings.EqualFold(name, "Authorization") {
+			return nil
+		}
+	}
+	if a.placeholder != "" {
+		spec.Headers = withHeader(spec.Headers, "Authorization", "Bearer "+a.placeholder)
+		return nil
+	}
+	if !a.loaded || !a.token.Valid() {
+		if !a.loaded {
+			if err := a.config.LoadClient(a.provider, a.profile); err != nil {
+				return err
+			}
+			a.loaded = true
+		}
+		token, err := a.config.Token(a.provider, a.profile)
+		if err != nil {
+			return err
+		}
+		a.token = token
+	}
+	a.log.addSecret(oauth.TokenSecret, a.token.AccessToken)
+	spec.Headers = withHeader(spec.Headers, "Authorization", a.token.Header())
+	return nil
+}
+
+// withHeader returns a copy of headers with name set, leaving the map the
+// script passed in untouched.
+func withHeader(headers map[string]string, name, value string) map[string]string {
+	out := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		out[k] = v
+	}
+	out[name] = value
+	return out
+}
+
+// authFromValue reads the fetch auth option; only false is meaningful.
+func authFromValue(optsVal *quickjs.Value) bool {
+	if optsVal == nil || !optsVal.IsObject() {
+		return true
+	}
+	val := optsVal.Get("auth")
+	defer val.Free()
+	return !val.IsBool() || val.ToBool()
+}
+
+// Default secret names for sigv4 credentials.
+const (
+	awsAccessKeySecret    = "aws_access_key_id"
+	awsSecretKeySecret    = "aws_secret_access_key"
+	awsSessionTokenSecret = "aws_session_token"
+)
+
+// sigv4FromValue reads the fetch sigv4 option, { region, service,
+// unsignedPayload, secrets: { accessKeyId, secretAccessKey, sessionToken } },
+// where secrets names the secrets holding the credentials. The session
+// token is optional.
+func (cfg *fetchConfig) sigv4FromValue(optsVal *quickjs.Value) (*request.SigV4, error) {
+	if optsVal == nil || !optsVal.IsObject() {
+		return nil, nil
+	}
+	val := optsVal.Get("sigv4")
+	defer val.Free()
+	if val.IsUndefined() || val.IsNull() {
+		return nil, nil
+	}
+	var opts struct {
+		Region          string `json:"region"`
+		Service         string `json:"service"`
+		UnsignedPayload bool   `json:"unsignedPayload"`
+		Secrets         struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+		} `json:"secrets"`
+	}
+	if err := json.Unmarshal([]byte(val.JSONStringify()), &opts); err != nil {
+		return nil, fmt.Errorf("invalid sigv4: %w", err)
+	}
+	if opts.Region == "" || opts.Service == "" {
+		return nil, fmt.Errorf("sigv4 needs region and service")
+	}
+	names := opts.Secrets
+	if names.AccessKeyID == "" {
+		names.AccessKeyID = awsAccessKeySecret
+	}
+	if names.SecretAccessKey == "" {
+		names.SecretAccessKey = awsSecretKeySecret
+	}
+	signer := &request.SigV4{Region: opts.Region, Service: opts.Service, UnsignedPayload: opts.UnsignedPayload}
+	var err error
+	if signer.AccessKeyID, err = cfg.secret(names.AccessKeyID); err != nil {
+		return nil, err
+	}
+	if signer.SecretAccessKey, err = cfg.secret(names.SecretAccessKey); err != nil {
+		return nil, err
+	}
+	if names.SessionToken == "" {
+		if _, ok := cfg.lookupSecret(awsSessionTokenSecret); ok {
+			names.SessionToken = awsSessionTokenSecret
+		}
+	}
+	if names.SessionToken != "" {
+		if signer.SessionToken, err = cfg.secret(names.SessionToken); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
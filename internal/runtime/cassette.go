@@ -0,0 +1,146 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/cassette"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// cassetteMode records every request to a cassette, or answers requests
+// from one. Secrets are stored as <name> placeholders, and replays run
+// without credentials by using those placeholders as the secret values.
+type cassetteMode struct {
+	cassette *cassette.Cassette
+	replay   bool
+	match    []string
+	// missed is the first request without a recorded response. It fails
+	// the run even when the script catches the fetch error.
+	missed error
+}
+
+func newCassetteMode(opts ExecOptions) (*cassetteMode, error) {
+	if err := cassette.ValidateMatch(opts.Match); err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.Replay != "":
+		c, err := cassette.Load(opts.Replay)
+		if err != nil {
+			return nil, err
+		}
+		return &cassetteMode{cassette: c, replay: true, match: opts.Match}, nil
+	case opts.Record != "":
+		return &cassetteMode{cassette: cassette.New(opts.Record)}, nil
+	}
+	return nil, nil
+}
+
+// replayMiss returns the first request the cassette had no response for.
+func (cfg *fetchConfig) replayMiss() error {
+	if cfg.cassette == nil {
+		return nil
+	}
+	return cfg.cassette.missed
+}
+
+func (cfg *fetchConfig) replay(spec request.Spec) (*request.Response, error) {
+	req, err := cfg.cassetteRequest(spec)
+	if err != nil {
+		return nil, err
+	}
+	interaction, err := cfg.cassette.cassette.Find(req, cfg.cassette.match)
+	if err != nil {
+		if cfg.cassette.missed == nil {
+			cfg.cassette.missed = err
+		}
+		return nil, err
+	}
+	recorded := interaction.Response
+	body := []byte(recorded.Body)
+	if recorded.Encoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(recorded.Body); err != nil {
+			return nil, fmt.Errorf("cassette response body: %w", err)
+		}
+	}
+	header := http.Header{}
+	for name, value := range recorded.Headers {
+		header.Set(name, value)
+	}
+	return request.NewResponse(recorded.Status, header, body), nil
+}
+
+func (cfg *fetchConfig) record(spec request.Spec, resp *request.Response) error {
+	req, err := cfg.cassetteRequest(spec)
+	if err != nil {
+		return err
+	}
+	recorded := cassette.Response{Status: resp.Status, Headers: cfg.scrubHeaders(resp.Headers)}
+	if utf8.Valid(resp.Body) {
+		recorded.Body = cfg.log.placeholders(string(resp.Body))
+	} else {
+		recorded.Body = base64.StdEncoding.EncodeToString(resp.Body)
+		recorded.Encoding = "base64"
+	}
+	return cfg.cassette.cassette.Add(cassette.Interaction{Request: req, Response: recorded})
+}
+
+// cassetteRequest describes spec the way it is stored in a cassette.
+func (cfg *fetchConfig) cassetteRequest(spec request.Spec) (cassette.Request, error) {
+	req, payload, err := request.NewRequest(spec)
+	if err != nil {
+		return cassette.Request{}, err
+	}
+	body := string(payload)
+	if !utf8.Valid(payload) {
+		body = fmt.Sprintf("<%d bytes of binary data>", len(payload))
+	}
+	return cassette.Request{
+		Method:  req.Method,
+		URL:     cfg.log.placeholders(req.URL.String()),
+		Headers: cfg.scrubHeaders(req.Header),
+		Body:    cfg.log.placeholders(body),
+	}, nil
+}
+
+func (cfg *fetchConfig) scrubHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(header))
+	for name, values := range header {
+		out[name] = cfg.log.placeholders(strings.Join(values, ", "))
+	}
+	return out
+}
+
+// bufferedStream fetches a streaming request in full, so it can be
+// captured, recorded, replayed or mocked, and then hands its events to the
+// script.
+func (cfg *fetchConfig) bufferedStream(ctx *quickjs.Context, spec request.Spec, stream *streamOptions) (*request.Response, []request.Event, error) {
+	spec.Headers = request.WithDefaultHeader(spec.Headers, "Accept", "text/event-stream")
+	resp, err := cfg.do(spec)
+	if err != nil || resp.Status >= 300 || !strings.HasPrefix(resp.Headers.Get("Content-Type"), "text/event-stream") {
+		return resp, nil, err
+	}
+	var events []request.Event
+	err = request.ReadEvents(bytes.NewReader(resp.Body), func(ev request.Event) error {
+		if stream.onEvent == nil {
+			events = append(events, ev)
+			return nil
+		}
+		return stream.dispatch(ctx, ev)
+	})
+	if err != nil && !errors.Is(err, request.ErrStopStream) {
+		return nil, nil, err
+	}
+	resp.Body, resp.ParsedJSON = nil, nil
+	return resp, events, nil
+}
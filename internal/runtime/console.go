@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// Log levels: warnings and errors always reach stderr, console.log/info and
+// retry or rate limit notes need --verbose, and console.debug and fetch
+// traces need --debug.
+const (
+	levelQuiet = iota
+	levelVerbose
+	levelDebug
+)
+
+const redacted = "[redacted]"
+
+// Query params named like these (api_key, access_token, X-Amz-Signature)
+// have their values hidden in logs.
+var (
+	sensitiveParams   = map[string]bool{"sig": true, "code": true, "auth": true}
+	sensitiveSuffixes = []string{"key", "token", "secret", "signature", "password", "credential"}
+)
+
+// debugLog writes diagnostics to stderr, replacing every secret value the
+// script has seen with [redacted].
+type debugLog struct {
+	w       io.Writer
+	level   int
+	secrets []namedSecret
+}
+
+// namedSecret is a secret value and the name it is shown as in snippets.
+type namedSecret struct {
+	name  string
+	value string
+}
+
+func (d *debugLog) enabled(level int) bool {
+	return d != nil && d.level >= level
+}
+
+// addSecret registers a value to redact. Short values are skipped since
+// they would mangle unrelated output.
+func (d *debugLog) addSecret(name, value string) {
+	if d == nil || len(value) < 4 {
+		return
+	}
+	for _, s := range d.secrets {
+		if s.value == value {
+			return
+		}
+	}
+	d.secrets = append(d.secrets, namedSecret{name, value})
+	// Longest first, so a secret containing another is replaced whole.
+	sort.Slice(d.secrets, func(i, j int) bool { return len(d.secrets[i].value) > len(d.secrets[j].value) })
+}
+
+func (d *debugLog) printf(level int, format string, args ...any) {
+	if !d.enabled(level) {
+		return
+	}
+	fmt.Fprint(d.w, d.redact(fmt.Sprintf(format, args...)))
+}
+
+func (d *debugLog) redact(s string) string {
+	for _, secret := range d.secrets {
+		s = strings.ReplaceAll(s, secret.value, redacted)
+	}
+	return s
+}
+
+// placeholders replaces secret values with <name>.
+func (d *debugLog) placeholders(s string) string {
+	for _, secret := range d.secrets {
+		s = strings.ReplaceAll(s, secret.value, "<"+secret.name+">")
+	}
+	return s
+}
+
+// redactURL hides the values of sensitive query params and any userinfo.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil && sensitiveParam(decoded) {
+			pairs[i] = name + "=" + redacted
+		}
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	return u.String()
+}
+
+func sensitiveParam(name string) bool {
+	lower := strings.ToLower(name)
+	if sensitiveParams[lower] {
+		return true
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// logFetch traces a finished request at --debug.
+func (d *debugLog) logFetch(spec request.Spec, resp *request.Response, err error, start time.Time) {
+	if !d.enabled(levelDebug) {
+		return
+	}
+	method := spec.Method
+	if method == "" {
+		method = "GET"
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case err != nil:
+		d.printf(levelDebug, "fetch %s %s failed after %s: %v\n", strings.ToUpper(method), redactURL(spec.URL), elapsed, err)
+	case resp.Headers.Get(request.CacheStatusHeader) != "":
+		d.printf(levelDebug, "fetch %s %s -> %d in %s (cache %s)\n", strings.ToUpper(method), redactURL(spec.URL), resp.Status, elapsed, resp.Headers.Get(request.CacheStatusHeader))
+	default:
+		d.printf(levelDebug, "fetch %s %s -> %d in %s\n", strings.ToUpper(method), redactURL(spec.URL), resp.Status, elapsed)
+	}
+}
+
+// newConsole builds the console global. Arguments are joined with spaces,
+// with objects shown as JSON.
+func newConsole(ctx *quickjs.Context, log *debugLog) *quickjs.Value {
+	obj := ctx.NewObject()
+	methods := map[string]int{
+		"log":   levelVerbose,
+		"info":  levelVerbose,
+		"debug": levelDebug,
+		"warn":  levelQuiet,
+		"error": levelQuiet,
+	}
+	for name, level := range methods {
+		obj.Set(name, ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+			if log.enabled(level) {
+				log.printf(level, "%s\n", formatConsoleArgs(args))
+			}
+			return ctx.NewUndefined()
+		}))
+	}
+	return obj
+}
+
+func formatConsoleArgs(args []*quickjs.Value) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case arg.IsString(), arg.IsError(), arg.IsFunction(), !arg.IsObject():
+			parts[i] = arg.ToString()
+		default:
+			parts[i] = arg.JSONStringify()
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,203 @@
+package runtime
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"time"
+
+	quickjs "github.com/buke/quickjs-go"
+)
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newCrypto builds the crypto global. Data arguments are strings (UTF-8)
+// or Uint8Array/ArrayBuffer values. Digests take an optional output
+// encoding: "hex" (default), "base64", "base64url" or "bytes".
+//
+//	crypto.sha256(data, enc)        crypto.sha512(data, enc)      crypto.sha1(data, enc)
+//	crypto.hmac(alg, key, data, enc)
+//	crypto.toHex(data)              crypto.fromHex(str, "utf8"?)
+//	crypto.toBase64(data)           crypto.fromBase64(str, "utf8"?)
+//	crypto.toBase64Url(data)        crypto.fromBase64Url(str, "utf8"?)
+//	crypto.randomBytes(n, enc)      crypto.randomUUID()           crypto.uuidv7()
+//	crypto.concat(...data)          crypto.timingSafeEqual(a, b)
+func newCrypto(ctx *quickjs.Context) *quickjs.Value {
+	obj := ctx.NewObject()
+	for name, newHash := range hashes {
+		obj.Set(name, ctx.NewFunction(digestFunc(name, newHash)))
+	}
+	obj.Set("hmac", ctx.NewFunction(hmacFunc))
+	obj.Set("toHex", ctx.NewFunction(encodeFunc("hex")))
+	obj.Set("toBase64", ctx.NewFunction(encodeFunc("base64")))
+	obj.Set("toBase64Url", ctx.NewFunction(encodeFunc("base64url")))
+	obj.Set("fromHex", ctx.NewFunction(decodeFunc("fromHex", hex.DecodeString)))
+	obj.Set("fromBase64", ctx.NewFunction(decodeFunc("fromBase64", decodeBase64)))
+	obj.Set("fromBase64Url", ctx.NewFunction(decodeFunc("fromBase64Url", decodeBase64URL)))
+	obj.Set("randomBytes", ctx.NewFunction(randomBytesFunc))
+	obj.Set("randomUUID", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		return ctx.NewString(uuidV4())
+	}))
+	obj.Set("uuidv7", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		return ctx.NewString(uuidV7(time.Now()))
+	}))
+	obj.Set("concat", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		var out []byte
+		for _, arg := range args {
+			out = append(out, dataFromValue(arg)...)
+		}
+		return ctx.NewUint8Array(out)
+	}))
+	obj.Set("timingSafeEqual", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) < 2 {
+			return ctx.ThrowInternalError("crypto.timingSafeEqual expects two values")
+		}
+		return ctx.NewBool(subtle.ConstantTimeCompare(dataFromValue(args[0]), dataFromValue(args[1])) == 1)
+	}))
+	return obj
+}
+
+func digestFunc(name string, newHash func() hash.Hash) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 {
+			return ctx.ThrowInternalError("crypto.%s expects data", name)
+		}
+		h := newHash()
+		h.Write(dataFromValue(args[0]))
+		return encodedValue(ctx, h.Sum(nil), optionalString(args, 1, "hex"))
+	}
+}
+
+func hmacFunc(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+	if len(args) < 3 {
+		return ctx.ThrowInternalError("crypto.hmac expects an algorithm, key and data")
+	}
+	newHash, ok := hashes[args[0].ToString()]
+	if !ok {
+		return ctx.ThrowInternalError("crypto.hmac: unknown algorithm %q (want sha1, sha256 or sha512)", args[0].ToString())
+	}
+	mac := hmac.New(newHash, dataFromValue(args[1]))
+	mac.Write(dataFromValue(args[2]))
+	return encodedValue(ctx, mac.Sum(nil), optionalString(args, 3, "hex"))
+}
+
+func encodeFunc(encoding string) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 {
+			return ctx.ThrowInternalError("crypto: expected data to encode")
+		}
+		return encodedValue(ctx, dataFromValue(args[0]), encoding)
+	}
+}
+
+// decodeFunc returns a Uint8Array, or a string when the second argument is
+// "utf8".
+func decodeFunc(name string, decode func(string) ([]byte, error)) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 {
+			return ctx.ThrowInternalError("crypto.%s expects a string", name)
+		}
+		b, err := decode(args[0].ToString())
+		if err != nil {
+			return ctx.ThrowInternalError("crypto.%s: %v", name, err)
+		}
+		if optionalString(args, 1, "bytes") == "utf8" {
+			return ctx.NewString(string(b))
+		}
+		return ctx.NewUint8Array(b)
+	}
+}
+
+func randomBytesFunc(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+	if len(args) == 0 || !args[0].IsNumber() {
+		return ctx.ThrowInternalError("crypto.randomBytes expects a length")
+	}
+	n := int(args[0].ToInt32())
+	if n < 0 || n > 1<<16 {
+		return ctx.ThrowInternalError("crypto.randomBytes: length must be between 0 and 65536")
+	}
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return encodedValue(ctx, b, optionalString(args, 1, "bytes"))
+}
+
+func encodedValue(ctx *quickjs.Context, b []byte, encoding string) *quickjs.Value {
+	switch encoding {
+	case "hex":
+		return ctx.NewString(hex.EncodeToString(b))
+	case "base64":
+		return ctx.NewString(base64.StdEncoding.EncodeToString(b))
+	case "base64url":
+		return ctx.NewString(base64.RawURLEncoding.EncodeToString(b))
+	case "bytes":
+		return ctx.NewUint8Array(b)
+	}
+	return ctx.ThrowInternalError("crypto: unknown encoding %q (want hex, base64, base64url or bytes)", encoding)
+}
+
+// dataFromValue reads bytes as-is and anything else as a UTF-8 string.
+func dataFromValue(val *quickjs.Value) []byte {
+	if b, ok := bytesFromValue(val); ok {
+		return b
+	}
+	return []byte(val.ToString())
+}
+
+func optionalString(args []*quickjs.Value, i int, fallback string) string {
+	if i >= len(args) || args[i].IsUndefined() || args[i].IsNull() {
+		return fallback
+	}
+	return args[i].ToString()
+}
+
+// decodeBase64 accepts padded and unpadded input.
+func decodeBase64(s string) ([]byte, error) {
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+func uuidV4() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// uuidV7 puts the Unix time in milliseconds in the first 48 bits, so the
+// ids sort by creation time.
+func uuidV7(now time.Time) string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+func formatUUID(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,108 @@
+package runtime
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/patrickjm/api-cli/internal/request"
+	"github.com/patrickjm/api-cli/internal/snippet"
+)
+
+// DryRunHeader marks the synthetic responses returned in dry-run mode.
+const DryRunHeader = "X-Api-Dry-Run"
+
+// capture takes the place of the network for --dry-run and --as: each
+// request is printed and answered with an empty JSON object.
+type capture struct {
+	w io.Writer
+	// format is a snippet format, or empty to print the request itself.
+	format string
+	// inline keeps secret values in snippets instead of <name> placeholders.
+	inline bool
+	count  int
+}
+
+func (cfg *fetchConfig) captureResponse(spec request.Spec) (*request.Response, error) {
+	req, payload, err := request.NewRequest(spec)
+	if err != nil {
+		return nil, err
+	}
+	var out string
+	if cfg.capture.format == "" {
+		out = cfg.log.redact(formatRequest(req, payload))
+	} else {
+		out, err = snippet.Format(cfg.capture.format, snippet.Request{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: req.Header,
+			Body:   payload,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.capture.inline {
+			out = cfg.log.placeholders(out)
+		}
+	}
+	if cfg.capture.count > 0 {
+		fmt.Fprintln(cfg.capture.w)
+	}
+	cfg.capture.count++
+	fmt.Fprintln(cfg.capture.w, out)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(DryRunHeader, "true")
+	return &request.Response{Status: http.StatusOK, Headers: header, Body: []byte("{}"), ParsedJSON: map[string]any{}}, nil
+}
+
+// formatRequest prints the method, URL, headers and body of req, hiding
+// sensitive query params and credentials.
+func formatRequest(req *http.Request, payload []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", req.Method, redactURL(req.URL.String()))
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			if strings.EqualFold(name, "Authorization") {
+				value = redactAuthorization(value)
+			}
+			fmt.Fprintf(&b, "\n%s: %s", name, value)
+		}
+	}
+	switch {
+	case len(payload) == 0:
+	case utf8.Valid(payload):
+		fmt.Fprintf(&b, "\n\n%s", payload)
+	default:
+		fmt.Fprintf(&b, "\n\n<%d bytes of binary data>", len(payload))
+	}
+	return b.String()
+}
+
+// redactAuthorization keeps the scheme of an Authorization header, and the
+// credential scope and signed headers of a SigV4 one.
+func redactAuthorization(value string) string {
+	scheme, rest, ok := strings.Cut(value, " ")
+	if !ok {
+		return redacted
+	}
+	if !strings.HasPrefix(scheme, "AWS4-") {
+		return scheme + " " + redacted
+	}
+	parts := strings.Split(rest, ", ")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "Signature=") {
+			parts[i] = "Signature=" + redacted
+		}
+	}
+	return scheme + " " + strings.Join(parts, ", ")
+}
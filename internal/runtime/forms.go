@@ -0,0 +1,185 @@
+package runtime
+
+import (
+	"errors"
+	"net/url"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// formsPrelude defines the URLSearchParams and FormData globals along with
+// file(path, { name, type }), which marks a FormData value as a local file
+// read by the fetch bridge when the request is sent.
+const formsPrelude = `
+(() => {
+  const encode = (s) => encodeURIComponent(s).replace(/%20/g, "+");
+  const decode = (s) => decodeURIComponent(s.replace(/\+/g, " "));
+  const hidden = (obj, name, value) => Object.defineProperty(obj, name, { value, writable: true });
+
+  class URLSearchParams {
+    constructor(init) {
+      hidden(this, "__entries", []);
+      if (init == null) return;
+      if (init instanceof URLSearchParams) {
+        init.forEach((v, k) => this.append(k, v));
+      } else if (typeof init === "string") {
+        for (const pair of init.replace(/^\?/, "").split("&")) {
+          if (!pair) continue;
+          const i = pair.indexOf("=");
+          this.append(decode(i < 0 ? pair : pair.slice(0, i)), i < 0 ? "" : decode(pair.slice(i + 1)));
+        }
+      } else if (Array.isArray(init)) {
+        for (const [k, v] of init) this.append(k, v);
+      } else if (typeof init === "object") {
+        for (const k of Object.keys(init)) {
+          if (init[k] !== undefined && init[k] !== null) this.append(k, init[k]);
+        }
+      }
+    }
+    append(name, value) { this.__entries.push([String(name), String(value)]); }
+    set(name, value) {
+      this.delete(name);
+      this.append(name, value);
+    }
+    get(name) {
+      const e = this.__entries.find(([k]) => k === String(name));
+      return e ? e[1] : null;
+    }
+    getAll(name) { return this.__entries.filter(([k]) => k === String(name)).map(([, v]) => v); }
+    has(name) { return this.__entries.some(([k]) => k === String(name)); }
+    delete(name) { this.__entries = this.__entries.filter(([k]) => k !== String(name)); }
+    forEach(fn) { for (const [k, v] of this.__entries) fn(v, k, this); }
+    entries() { return this.__entries.map((e) => e.slice())[Symbol.iterator](); }
+    keys() { return this.__entries.map(([k]) => k)[Symbol.iterator](); }
+    values() { return this.__entries.map(([, v]) => v)[Symbol.iterator](); }
+    [Symbol.iterator]() { return this.entries(); }
+    get size() { return this.__entries.length; }
+    toString() { return this.__entries.map(([k, v]) => encode(k) + "=" + encode(v)).join("&"); }
+  }
+
+  class LocalFile {
+    constructor(path, opts) {
+      this.path = String(path);
+      this.name = (opts && opts.name) || this.path.split(/[\\/]/).pop();
+      this.type = (opts && opts.type) || "";
+    }
+  }
+
+  class FormData {
+    constructor() { hidden(this, "__entries", []); }
+    append(name, value, filename) { this.__entries.push(FormData.entry(name, value, filename)); }
+    set(name, value, filename) {
+      this.delete(name);
+      this.append(name, value, filename);
+    }
+    get(name) {
+      const e = this.__entries.find((e) => e.name === String(name));
+      return e ? e.value : null;
+    }
+    getAll(name) { return this.__entries.filter((e) => e.name === String(name)).map((e) => e.value); }
+    has(name) { return this.__entries.some((e) => e.name === String(name)); }
+    delete(name) { this.__entries = this.__entries.filter((e) => e.name !== String(name)); }
+    forEach(fn) { for (const e of this.__entries) fn(e.value, e.name, this); }
+    entries() { return this.__entries.map((e) => [e.name, e.value])[Symbol.iterator](); }
+    keys() { return this.__entries.map((e) => e.name)[Symbol.iterator](); }
+    values() { return this.__entries.map((e) => e.value)[Symbol.iterator](); }
+    [Symbol.iterator]() { return this.entries(); }
+    static entry(name, value, filename) {
+      const e = { name: String(name), value };
+      if (value instanceof LocalFile) {
+        e.path = value.path;
+        e.filename = filename === undefined ? value.name : String(filename);
+        e.type = value.type;
+      } else if (value instanceof ArrayBuffer || ArrayBuffer.isView(value)) {
+        e.filename = filename === undefined ? "blob" : String(filename);
+      } else {
+        e.value = String(value);
+      }
+      return e;
+    }
+  }
+
+  globalThis.URLSearchParams = URLSearchParams;
+  globalThis.FormData = FormData;
+  globalThis.file = (path, opts) => new LocalFile(path, opts);
+})();
+`
+
+func installForms(ctx *quickjs.Context) error {
+	val := ctx.Eval(formsPrelude, quickjs.EvalFileName("<api:forms>"))
+	defer val.Free()
+	if val.IsException() {
+		return exceptionError(ctx)
+	}
+	return nil
+}
+
+// formBody converts a URLSearchParams or FormData value into the matching
+// request body type. ok is false for any other value.
+func formBody(val *quickjs.Value) (body any, ok bool, err error) {
+	if val == nil || !val.IsObject() {
+		return nil, false, nil
+	}
+	switch {
+	case val.GlobalInstanceof("URLSearchParams"):
+		entries := val.Get("__entries")
+		defer entries.Free()
+		values := url.Values{}
+		for i := int64(0); i < entries.Len(); i++ {
+			entry := entries.GetIdx(i)
+			k, v := entry.GetIdx(0), entry.GetIdx(1)
+			values.Add(k.ToString(), v.ToString())
+			k.Free()
+			v.Free()
+			entry.Free()
+		}
+		return values, true, nil
+	case val.GlobalInstanceof("FormData"):
+		entries := val.Get("__entries")
+		defer entries.Free()
+		form := &request.Multipart{}
+		for i := int64(0); i < entries.Len(); i++ {
+			entry := entries.GetIdx(i)
+			part, err := formPart(entry)
+			entry.Free()
+			if err != nil {
+				return nil, true, err
+			}
+			form.Parts = append(form.Parts, part)
+		}
+		return form, true, nil
+	}
+	return nil, false, nil
+}
+
+func formPart(entry *quickjs.Value) (request.Part, error) {
+	get := func(name string) string {
+		v := entry.Get(name)
+		defer v.Free()
+		if v.IsUndefined() || v.IsNull() {
+			return ""
+		}
+		return v.ToString()
+	}
+	part := request.Part{
+		Name:        get("name"),
+		Filename:    get("filename"),
+		ContentType: get("type"),
+		Path:        get("path"),
+	}
+	value := entry.Get("value")
+	defer value.Free()
+	switch {
+	case part.Path != "":
+	case entry.Has("filename"):
+		b, ok := bytesFromValue(value)
+		if !ok {
+			return request.Part{}, errors.New("form file " + part.Name + " has no content")
+		}
+		part.Data = b
+	default:
+		part.Value = value.ToString()
+	}
+	return part, nil
+}
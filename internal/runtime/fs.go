@@ -0,0 +1,160 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// sandbox limits script file access to the working directory and any
+// directories allowed with --allow-path.
+type sandbox struct {
+	cwd   string
+	roots []string
+}
+
+func newSandbox(allow []string) (*sandbox, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	s := &sandbox{cwd: cwd}
+	for _, dir := range append([]string{cwd}, allow...) {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cwd, dir)
+		}
+		s.roots = append(s.roots, realPath(filepath.Clean(dir)))
+	}
+	return s, nil
+}
+
+// resolve returns the absolute path for name, following symlinks, or an
+// error when it falls outside every allowed root.
+func (s *sandbox) resolve(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("path is empty")
+	}
+	target := name
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(s.cwd, target)
+	}
+	target = realPath(filepath.Clean(target))
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(root, target)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("path %s is outside the allowed directories (use --allow-path)", name)
+}
+
+// resolveSpec checks the local files referenced by a multipart body.
+func (s *sandbox) resolveSpec(spec *request.Spec) error {
+	form, ok := spec.Body.(*request.Multipart)
+	if !ok {
+		return nil
+	}
+	for i, part := range form.Parts {
+		if part.Path == "" {
+			continue
+		}
+		path, err := s.resolve(part.Path)
+		if err != nil {
+			return err
+		}
+		form.Parts[i].Path = path
+	}
+	return nil
+}
+
+// realPath resolves symlinks in the longest existing prefix of path, so
+// files that do not exist yet still resolve through linked parents.
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path
+	}
+	return filepath.Join(realPath(parent), filepath.Base(path))
+}
+
+func readFileFunc(s *sandbox) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 {
+			return ctx.ThrowInternalError("readFile expects a path")
+		}
+		path, err := s.resolve(args[0].ToString())
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return ctx.ThrowInternalError("readFile: %v", err)
+		}
+		if len(args) > 1 && args[1].IsString() && args[1].ToString() == "binary" {
+			return ctx.NewUint8Array(b)
+		}
+		return ctx.NewString(string(b))
+	}
+}
+
+func writeFileFunc(s *sandbox) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) < 2 {
+			return ctx.ThrowInternalError("writeFile expects a path and data")
+		}
+		path, err := s.resolve(args[0].ToString())
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		data, ok := bytesFromValue(args[1])
+		if !ok {
+			data = []byte(args[1].ToString())
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return ctx.ThrowInternalError("writeFile: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return ctx.ThrowInternalError("writeFile: %v", err)
+		}
+		return ctx.NewUndefined()
+	}
+}
+
+func listDirFunc(s *sandbox) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		name := "."
+		if len(args) > 0 && !args[0].IsUndefined() {
+			name = args[0].ToString()
+		}
+		path, err := s.resolve(name)
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return ctx.ThrowInternalError("listDir: %v", err)
+		}
+		list := ctx.ParseJSON("[]")
+		for i, entry := range entries {
+			item := ctx.NewObject()
+			item.Set("name", ctx.NewString(entry.Name()))
+			item.Set("dir", ctx.NewBool(entry.IsDir()))
+			var size int64
+			if info, err := entry.Info(); err == nil && info.Mode()&fs.ModeType == 0 {
+				size = info.Size()
+			}
+			item.Set("size", ctx.NewInt64(size))
+			list.SetIdx(int64(i), item)
+		}
+		return list
+	}
+}
@@ -0,0 +1,143 @@
+package runtime
+
+import (
+	"fmt"
+	"net/http"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// hooksPrelude evaluates to a function that wraps the bridge's fetch with
+// the beforeRequest, afterResponse and onError hooks of a default export.
+// Requests are normalized to a single options object with a copied headers
+// object before beforeRequest sees them. Fetches made while a hook runs, or
+// while an async hook has yet to settle, skip the hooks, so a hook can call
+// fetch without recursing into itself.
+const hooksPrelude = `
+((provider, send) => {
+  let depth = 0;
+  const call = (name, arg) => {
+    depth++;
+    let out;
+    try {
+      out = provider[name](arg);
+    } catch (err) {
+      depth--;
+      throw err;
+    }
+    if (out instanceof Promise) return out.finally(() => depth--);
+    depth--;
+    return out;
+  };
+  const then = (v, fn) => (v instanceof Promise ? v.then(fn) : fn(v));
+  const normalize = (args) => {
+    const req = typeof args[0] === "string" ? { ...(args[1] || {}), url: args[0] } : { ...(args[0] || {}) };
+    req.headers = { ...(req.headers || {}) };
+    return req;
+  };
+  const before = (args) => {
+    if (typeof provider.beforeRequest !== "function") return args;
+    const req = normalize(args);
+    return then(call("beforeRequest", req), (out) => [out === undefined ? req : out]);
+  };
+  const after = (resp) => {
+    if (typeof provider.afterResponse !== "function") return resp;
+    return then(call("afterResponse", resp), (out) => (out === undefined ? resp : out));
+  };
+  const fail = (err) => {
+    if (typeof provider.onError !== "function") throw err;
+    return then(call("onError", err), (out) => {
+      if (out === undefined) throw err;
+      return out;
+    });
+  };
+  const fetch = (...args) => {
+    if (depth > 0) return send(...args);
+    return then(before(args), (args) => {
+      let resp;
+      try {
+        resp = send(...args);
+      } catch (err) {
+        return fail(err);
+      }
+      return resp instanceof Promise ? resp.then(after, fail) : after(resp);
+    });
+  };
+  const page = (req, url) => fetch(typeof req === "string" ? url : { ...req, url });
+  return { fetch, page };
+})
+`
+
+// hookNames are the default export keys that hold fetch hooks.
+var hookNames = []string{"beforeRequest", "afterResponse", "onError"}
+
+// providerHooks holds the JS side of a provider's fetch hooks.
+type providerHooks struct {
+	fetch *quickjs.Value
+	page  *quickjs.Value
+}
+
+// hooksFromValue returns the hooks declared on the default export, or nil
+// when it declares none. send is the fetch bridge without hooks.
+func hooksFromValue(ctx *quickjs.Context, defaultVal *quickjs.Value, send *quickjs.Value) (*providerHooks, error) {
+	found := false
+	for _, name := range hookNames {
+		val := defaultVal.Get(name)
+		defined := !val.IsUndefined() && !val.IsNull()
+		isFunc := val.IsFunction()
+		val.Free()
+		if defined && !isFunc {
+			return nil, fmt.Errorf("%s must be a function", name)
+		}
+		found = found || defined
+	}
+	if !found {
+		return nil, nil
+	}
+	factory := ctx.Eval(hooksPrelude, quickjs.EvalFileName("<api:hooks>"))
+	defer factory.Free()
+	if factory.IsException() {
+		return nil, exceptionError(ctx)
+	}
+	wrapper := factory.Execute(ctx.NewUndefined(), defaultVal, send)
+	defer wrapper.Free()
+	if wrapper.IsException() {
+		return nil, exceptionError(ctx)
+	}
+	return &providerHooks{fetch: wrapper.Get("fetch"), page: wrapper.Get("page")}, nil
+}
+
+func (h *providerHooks) free() {
+	h.fetch.Free()
+	h.page.Free()
+}
+
+// fetchPage sends one page of a paginate() request through the hooked fetch
+// and waits for it, so every hook, async ones included, sees each page. req
+// is the request paginate() was given; pageURL replaces its url.
+func (h *providerHooks) fetchPage(ctx *quickjs.Context, req *quickjs.Value, pageURL string) (*request.Response, error) {
+	urlVal := ctx.NewString(pageURL)
+	defer urlVal.Free()
+	out := h.page.Execute(ctx.NewUndefined(), req, urlVal)
+	if out.IsException() {
+		out.Free()
+		return nil, exceptionError(ctx)
+	}
+	val, err := awaitValue(ctx, out)
+	if err != nil {
+		return nil, err
+	}
+	defer val.Free()
+	// afterResponse may have replaced the response with its data.
+	res := resultFromValue(val)
+	status := res.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := res.JSON
+	if body == "" {
+		body = res.Body
+	}
+	return request.NewResponse(status, headersFromValue(val), []byte(body)), nil
+}
@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TestFile is the results of one test file. Err is set when the file could
+// not be loaded at all.
+type TestFile struct {
+	Name    string
+	Results []TestResult
+	Err     error
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as JUnit XML, one testsuite per file.
+func WriteJUnit(w io.Writer, files []TestFile) error {
+	var out junitSuites
+	for _, f := range files {
+		suite := junitSuite{Name: f.Name}
+		var total time.Duration
+		if f.Err != nil {
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      "load",
+				Classname: f.Name,
+				Time:      seconds(0),
+				Failure:   &junitFailure{Message: f.Err.Error(), Text: f.Err.Error()},
+			})
+			suite.Failures++
+		}
+		for _, r := range f.Results {
+			c := junitCase{Name: r.Name, Classname: f.Name, Time: seconds(r.Duration)}
+			if r.Suite != "" {
+				c.Classname = f.Name + " > " + r.Suite
+			}
+			switch {
+			case r.Skipped:
+				c.Skipped = &struct{}{}
+				suite.Skipped++
+			case r.Failure != "":
+				c.Failure = &junitFailure{Message: r.Failure, Text: r.Failure}
+				suite.Failures++
+			}
+			total += r.Duration
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+		suite.Time = seconds(total)
+		out.Tests += suite.Tests
+		out.Failures += suite.Failures
+		out.Skipped += suite.Skipped
+		out.Suites = append(out.Suites, suite)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/jwt"
+)
+
+// jwtKeys resolves key references for the jwt global, so private keys are
+// read from the secret store in Go and never reach the script.
+type jwtKeys struct {
+	secret func(name string) (string, error)
+	files  *sandbox
+}
+
+// jwtOptions are the options accepted as the third argument of jwt.sign
+// and jwt.verify, which may also be just the algorithm name.
+type jwtOptions struct {
+	Alg       string         `json:"alg"`
+	Algs      []string       `json:"algs"`
+	KeyID     string         `json:"kid"`
+	Header    map[string]any `json:"header"`
+	ExpiresIn float64        `json:"expiresIn"`
+	Leeway    float64        `json:"leeway"`
+}
+
+// newJWT builds the jwt global:
+//
+//	jwt.sign(claims, keyRef, alg | { alg, kid, header, expiresIn })
+//	jwt.verify(token, keyRef, alg | { alg, algs, leeway })
+//	jwt.decode(token)
+//
+// keyRef is a secret name, or { secret }, { file } or { pem }.
+func newJWT(ctx *quickjs.Context, keys *jwtKeys) *quickjs.Value {
+	obj := ctx.NewObject()
+	obj.Set("sign", ctx.NewFunction(keys.signFunc))
+	obj.Set("verify", ctx.NewFunction(keys.verifyFunc))
+	obj.Set("decode", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 {
+			return ctx.ThrowInternalError("jwt.decode expects a token")
+		}
+		header, claims, err := jwt.Decode(args[0].ToString())
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		return jsonValue(ctx, map[string]any{"header": header, "payload": claims})
+	}))
+	return obj
+}
+
+func (k *jwtKeys) signFunc(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+	if len(args) < 2 || !args[0].IsObject() {
+		return ctx.ThrowInternalError("jwt.sign expects claims and a key")
+	}
+	opts, err := jwtOptionsFromArgs(args, 2)
+	if err != nil {
+		return ctx.ThrowInternalError("jwt.sign: %v", err)
+	}
+	var claims map[string]any
+	if err := decodeNumbers(args[0].JSONStringify(), &claims); err != nil {
+		return ctx.ThrowInternalError("jwt.sign: invalid claims: %v", err)
+	}
+	key, kid, err := k.load(args[1], opts.Alg)
+	if err != nil {
+		return ctx.ThrowInternalError("jwt.sign: %v", err)
+	}
+	now := time.Now().Unix()
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now
+	}
+	if opts.ExpiresIn > 0 {
+		claims["exp"] = now + int64(opts.ExpiresIn)
+	}
+	if opts.KeyID == "" {
+		opts.KeyID = kid
+	}
+	token, err := jwt.Sign(claims, key, jwt.SignOptions{Alg: opts.Alg, KeyID: opts.KeyID, Header: opts.Header})
+	if err != nil {
+		return ctx.ThrowInternalError("%v", err)
+	}
+	return ctx.NewString(token)
+}
+
+// verifyFunc returns the claims of a valid token and throws otherwise.
+func (k *jwtKeys) verifyFunc(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+	if len(args) < 2 {
+		return ctx.ThrowInternalError("jwt.verify expects a token and a key")
+	}
+	opts, err := jwtOptionsFromArgs(args, 2)
+	if err != nil {
+		return ctx.ThrowInternalError("jwt.verify: %v", err)
+	}
+	key, _, err := k.load(args[1], opts.Alg)
+	if err != nil {
+		return ctx.ThrowInternalError("jwt.verify: %v", err)
+	}
+	algs := opts.Algs
+	if opts.Alg != "" {
+		algs = append(algs, opts.Alg)
+	}
+	claims, err := jwt.Verify(args[0].ToString(), key, jwt.VerifyOptions{
+		Algs:   algs,
+		Leeway: time.Duration(opts.Leeway * float64(time.Second)),
+	})
+	if err != nil {
+		return ctx.ThrowInternalError("%v", err)
+	}
+	return jsonValue(ctx, claims)
+}
+
+// load reads the key behind keyRef. A Google service account JSON key is
+// unwrapped to its private_key, with private_key_id as the key id.
+func (k *jwtKeys) load(ref *quickjs.Value, alg string) (any, string, error) {
+	var data []byte
+	switch {
+	case ref.IsString():
+		value, err := k.secret(ref.ToString())
+		if err != nil {
+			return nil, "", err
+		}
+		data = []byte(value)
+	case ref.IsObject():
+		var src struct {
+			Secret string `json:"secret"`
+			File   string `json:"file"`
+			PEM    string `json:"pem"`
+		}
+		if err := json.Unmarshal([]byte(ref.JSONStringify()), &src); err != nil {
+			return nil, "", fmt.Errorf("invalid key: %v", err)
+		}
+		switch {
+		case src.Secret != "":
+			value, err := k.secret(src.Secret)
+			if err != nil {
+				return nil, "", err
+			}
+			data = []byte(value)
+		case src.File != "":
+			path, err := k.files.resolve(src.File)
+			if err != nil {
+				return nil, "", err
+			}
+			if data, err = os.ReadFile(path); err != nil {
+				return nil, "", err
+			}
+		case src.PEM != "":
+			data = []byte(src.PEM)
+		default:
+			return nil, "", errors.New("key must name a secret, file or pem")
+		}
+	default:
+		return nil, "", errors.New("key must be a secret name or { secret | file | pem }")
+	}
+
+	var kid string
+	var account struct {
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+	}
+	if json.Unmarshal(data, &account) == nil && account.PrivateKey != "" {
+		data, kid = []byte(account.PrivateKey), account.PrivateKeyID
+	}
+	key, err := jwt.ParseKey(data, alg)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, kid, nil
+}
+
+func jwtOptionsFromArgs(args []*quickjs.Value, i int) (jwtOptions, error) {
+	var opts jwtOptions
+	if i >= len(args) || args[i].IsUndefined() || args[i].IsNull() {
+		return opts, nil
+	}
+	if args[i].IsString() {
+		opts.Alg = args[i].ToString()
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(args[i].JSONStringify()), &opts); err != nil {
+		return opts, fmt.Errorf("invalid options: %v", err)
+	}
+	return opts, nil
+}
+
+// decodeNumbers keeps integer claims like exp intact instead of turning
+// them into floats.
+func decodeNumbers(s string, out any) error {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+func jsonValue(ctx *quickjs.Context, v any) *quickjs.Value {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ctx.ThrowInternalError("%v", err)
+	}
+	return ctx.ParseJSON(string(b))
+}
@@ -0,0 +1,134 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/config"
+)
+
+var (
+	importSyntax  = regexp.MustCompile(`(?m)^\s*import\b|\bimport\s*\(`)
+	moduleSyntax  = regexp.MustCompile(`(?m)^\s*(?:import|export)\b`)
+	importPattern = regexp.MustCompile(`(?m)^\s*(?:import|export)\s*(?:[^'";]*?\bfrom\s*)?["']([^"']+)["']`)
+	// dynamicImportPattern finds import() calls with a literal specifier,
+	// the only ones that can be loaded ahead of time.
+	dynamicImportPattern = regexp.MustCompile(`\bimport\s*\(\s*["']([^"']+)["']\s*\)`)
+)
+
+// providerModuleName is the module name the provider script is compiled
+// under. Relative imports in the script resolve against the providers dir.
+var providerModuleName = path.Join(config.ProvidersDirName, "provider.js")
+
+type moduleSource struct {
+	name string
+	code string
+}
+
+// loadScript evaluates a provider script and leaves its default export in
+// globalThis.__api_default__. Scripts that import other modules are loaded as
+// ES modules, with imports resolved against moduleDir (the config dir).
+// Modules are loaded before the script runs, so import() only works with a
+// string literal specifier.
+// Others run as classic scripts, as they always have, unless they only parse
+// as a module.
+func loadScript(ctx *quickjs.Context, script []byte, moduleDir string) error {
+	source := string(script)
+	if !importSyntax.MatchString(source) {
+		err := evalClassic(ctx, source)
+		var jsErr *quickjs.Error
+		if err == nil || !moduleSyntax.MatchString(source) || !errors.As(err, &jsErr) || jsErr.Name != "SyntaxError" {
+			return err
+		}
+	}
+	deps, err := resolveImports(moduleDir, providerModuleName, source, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	deps = append(deps, moduleSource{name: providerModuleName, code: source})
+	for _, mod := range deps {
+		val := ctx.Eval(mod.code, quickjs.EvalFlagModule(true), quickjs.EvalFlagCompileOnly(true), quickjs.EvalFileName(mod.name))
+		if val.IsException() {
+			val.Free()
+			return fmt.Errorf("%s: %w", mod.name, exceptionError(ctx))
+		}
+		val.Free()
+	}
+	bootstrap := fmt.Sprintf("import * as provider from %q;\nglobalThis.__api_default__ = provider.default;\n", providerModuleName)
+	val := ctx.Eval(bootstrap, quickjs.EvalFlagModule(true), quickjs.EvalFileName("<api>"))
+	if val.IsException() {
+		val.Free()
+		return exceptionError(ctx)
+	}
+	val, err = awaitValue(ctx, val)
+	if err != nil {
+		return err
+	}
+	val.Free()
+	return nil
+}
+
+// evalClassic runs a script in sloppy mode with its export default turned
+// into an assignment to globalThis.__api_default__.
+func evalClassic(ctx *quickjs.Context, source string) error {
+	val := ctx.Eval(strings.Replace(source, "export default", "globalThis.__api_default__ =", 1))
+	defer val.Free()
+	if val.IsException() {
+		return exceptionError(ctx)
+	}
+	return nil
+}
+
+// resolveImports returns the modules imported by source, transitively, with
+// dependencies ordered before the modules that import them.
+func resolveImports(moduleDir, importer, source string, seen map[string]bool) ([]moduleSource, error) {
+	var out []moduleSource
+	matches := append(importPattern.FindAllStringSubmatch(source, -1), dynamicImportPattern.FindAllStringSubmatch(source, -1)...)
+	for _, match := range matches {
+		name, err := resolveModuleName(importer, match[1])
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if moduleDir == "" {
+			return nil, fmt.Errorf("module not found: %s", name)
+		}
+		b, err := os.ReadFile(filepath.Join(moduleDir, filepath.FromSlash(name)))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("module not found: %s", name)
+			}
+			return nil, err
+		}
+		deps, err := resolveImports(moduleDir, name, string(b), seen)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, deps...)
+		out = append(out, moduleSource{name: name, code: string(b)})
+	}
+	return out, nil
+}
+
+// resolveModuleName mirrors the QuickJS module name normalization: relative
+// specifiers resolve against the importing module, others are used as-is.
+func resolveModuleName(importer, specifier string) (string, error) {
+	name := specifier
+	if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+		name = path.Join(path.Dir(importer), specifier)
+	}
+	name = path.Clean(name)
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("module %s is outside the config dir", specifier)
+	}
+	return name, nil
+}
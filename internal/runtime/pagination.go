@@ -0,0 +1,397 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+// Pagination styles.
+const (
+	PageCursor = "cursor"
+	PageLink   = "link"
+	PageNumber = "page"
+	PageOffset = "offset"
+)
+
+// Pagination describes how a list command pages through results. It is
+// declared as the command's pagination metadata and passed to paginate().
+type Pagination struct {
+	// Style is cursor (default), link, page or offset.
+	Style string `json:"style,omitempty"`
+	// Items is the dot path to the item array; empty when the page is one.
+	Items string `json:"items,omitempty"`
+	// Param is the request param that selects the page.
+	Param string `json:"param,omitempty"`
+	// Next is the dot path to the next cursor (cursor style) or next-page
+	// URL (link style) in the page.
+	Next string `json:"next,omitempty"`
+	// NextFromItem takes the cursor from this path in the last item.
+	NextFromItem string `json:"nextFromItem,omitempty"`
+	// Header holds the rel="next" link for link style when Next is unset.
+	Header string `json:"header,omitempty"`
+	// Start is the number of the first page for page style.
+	Start int `json:"start,omitempty"`
+	// MaxItems stops paginate() after this many items.
+	MaxItems int `json:"maxItems,omitempty"`
+}
+
+var linkNextPattern = regexp.MustCompile(`<([^>]*)>\s*;[^,]*\brel="?next"?`)
+
+func (p *Pagination) validate() error {
+	switch p.Style {
+	case "":
+		p.Style = PageCursor
+	case PageCursor, PageLink, PageNumber, PageOffset:
+	default:
+		return fmt.Errorf("unknown pagination style %q", p.Style)
+	}
+	if p.Style == PageCursor && p.Next == "" && p.NextFromItem == "" {
+		return errors.New("cursor pagination needs next or nextFromItem")
+	}
+	if p.Style != PageLink && p.Param == "" {
+		return fmt.Errorf("%s pagination needs param", p.Style)
+	}
+	if p.Header == "" {
+		p.Header = "Link"
+	}
+	if p.Start == 0 {
+		p.Start = 1
+	}
+	return nil
+}
+
+// pageStep is the outcome of reading one page: its items and where the
+// next page is, as a param value and, for link style, a full URL.
+type pageStep struct {
+	items   []any
+	cursor  string
+	nextURL string
+}
+
+// step reads a page fetched with the param set to current ("" for the
+// first page). An empty cursor and nextURL mean there are no more pages.
+func (p *Pagination) step(page any, header http.Header, current string) (pageStep, error) {
+	itemsVal := lookupPath(page, p.Items)
+	if itemsVal == nil {
+		return pageStep{}, nil
+	}
+	items, ok := itemsVal.([]any)
+	if !ok {
+		return pageStep{}, fmt.Errorf("pagination items %q is not an array", p.Items)
+	}
+	step := pageStep{items: items}
+	if len(items) == 0 {
+		return step, nil
+	}
+	switch p.Style {
+	case PageCursor:
+		if p.NextFromItem != "" {
+			step.cursor = scalarString(lookupPath(items[len(items)-1], p.NextFromItem))
+		} else {
+			step.cursor = scalarString(lookupPath(page, p.Next))
+		}
+	case PageLink:
+		if p.Next != "" {
+			step.nextURL = scalarString(lookupPath(page, p.Next))
+		} else if m := linkNextPattern.FindStringSubmatch(header.Get(p.Header)); m != nil {
+			step.nextURL = m[1]
+		}
+		if step.nextURL != "" && p.Param != "" {
+			if u, err := url.Parse(step.nextURL); err == nil {
+				step.cursor = u.Query().Get(p.Param)
+			}
+		}
+	case PageNumber:
+		n := p.Start
+		if current != "" {
+			var err error
+			if n, err = strconv.Atoi(current); err != nil {
+				return pageStep{}, fmt.Errorf("page param %q is not a number", current)
+			}
+		}
+		step.cursor = strconv.Itoa(n + 1)
+	case PageOffset:
+		n := 0
+		if current != "" {
+			var err error
+			if n, err = strconv.Atoi(current); err != nil {
+				return pageStep{}, fmt.Errorf("offset param %q is not a number", current)
+			}
+		}
+		step.cursor = strconv.Itoa(n + len(items))
+	}
+	if step.cursor != "" && step.cursor == current {
+		// A server that hands back the same cursor would loop forever.
+		step.cursor, step.nextURL = "", ""
+	}
+	return step, nil
+}
+
+// lookupPath follows a dot path like "data.items" or "results.0.id".
+func lookupPath(v any, path string) any {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func scalarString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return ""
+}
+
+// paginationFromValue reads a pagination descriptor, returning nil when the
+// value is unset.
+func paginationFromValue(val *quickjs.Value) (*Pagination, error) {
+	if val == nil || val.IsUndefined() || val.IsNull() {
+		return nil, nil
+	}
+	var p Pagination
+	if err := json.Unmarshal([]byte(val.JSONStringify()), &p); err != nil {
+		return nil, fmt.Errorf("invalid pagination: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid pagination: %w", err)
+	}
+	return &p, nil
+}
+
+// runAllPages invokes the command once per page, advancing the pagination
+// param, and passes each item to onItem until the pages run out or
+// maxItems is reached. A page with an error status is returned as the
+// result so the caller can report it.
+func runAllPages(ctx *quickjs.Context, defaultVal *quickjs.Value, opts ExecOptions, fetchCfg *fetchConfig) (*ExecResult, error) {
+	entry := defaultVal.Get(opts.Command)
+	defer entry.Free()
+	if !entry.IsObject() || providerKeys[opts.Command] {
+		return nil, fmt.Errorf("command not found: %s", opts.Command)
+	}
+	pagVal := entry.Get("pagination")
+	pagination, err := paginationFromValue(pagVal)
+	pagVal.Free()
+	if err != nil {
+		return nil, commandError(opts, err)
+	}
+	if pagination == nil {
+		return nil, commandError(opts, errors.New("command does not declare pagination, so --all is not supported"))
+	}
+	params := make(map[string]string, len(opts.Params)+1)
+	for k, v := range opts.Params {
+		params[k] = v
+	}
+	onItem := opts.OnItem
+	if onItem == nil {
+		onItem = func(item json.RawMessage) error {
+			_, err := fmt.Fprintf(opts.Stdout, "%s\n", item)
+			return err
+		}
+	}
+	count := 0
+	for {
+		opts.Params = params
+		resultVal, err := invokeCommand(ctx, defaultVal, opts, fetchCfg)
+		if err != nil {
+			return nil, err
+		}
+		res := resultFromValue(resultVal)
+		header := headersFromValue(resultVal)
+		resultVal.Free()
+		if res.Status >= 400 {
+			return res, nil
+		}
+		var page any
+		if err := json.Unmarshal([]byte(res.JSON), &page); err != nil {
+			return nil, fmt.Errorf("page is not JSON: %w", err)
+		}
+		step, err := pagination.step(page, header, params[pagination.Param])
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range step.items {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := onItem(b); err != nil {
+				return nil, err
+			}
+			count++
+			if opts.MaxItems > 0 && count >= opts.MaxItems {
+				return &ExecResult{Status: res.Status}, nil
+			}
+		}
+		if step.cursor == "" {
+			if step.nextURL != "" {
+				return nil, commandError(opts, fmt.Errorf("link pagination needs param to follow %s", step.nextURL))
+			}
+			return &ExecResult{Status: res.Status}, nil
+		}
+		params[pagination.Param] = step.cursor
+	}
+}
+
+// headersFromValue reads the headers object of a fetch response value.
+func headersFromValue(val *quickjs.Value) http.Header {
+	header := http.Header{}
+	if !val.IsObject() {
+		return header
+	}
+	headersVal := val.Get("headers")
+	defer headersVal.Free()
+	if !headersVal.IsObject() {
+		return header
+	}
+	var values map[string]string
+	if json.Unmarshal([]byte(headersVal.JSONStringify()), &values) == nil {
+		for k, v := range values {
+			header.Set(k, v)
+		}
+	}
+	return header
+}
+
+// paginateFunc implements paginate(request, pagination), which fetches every
+// page of a list endpoint and returns the items as one array.
+func paginateFunc(cfg *fetchConfig) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) < 2 {
+			return ctx.ThrowInternalError("paginate expects a request and a pagination descriptor")
+		}
+		spec, optsVal, err := specFromArgs(args[:1])
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		pagination, err := paginationFromValue(args[1])
+		if err == nil && pagination == nil {
+			err = errors.New("pagination descriptor is required")
+		}
+		if err != nil {
+			return ctx.ThrowInternalError("paginate: %v", err)
+		}
+		fetchPage := func(pageURL string) (*request.Response, error) {
+			pageSpec := spec
+			pageSpec.URL = pageURL
+			return cfg.do(pageSpec)
+		}
+		if cfg.hooks != nil {
+			// Pages go through the hooked fetch, which prepares each request.
+			fetchPage = func(pageURL string) (*request.Response, error) {
+				return cfg.hooks.fetchPage(ctx, args[0], pageURL)
+			}
+		} else if err := cfg.prepare(&spec, optsVal); err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		items, err := fetchAllPages(spec.URL, pagination, fetchPage)
+		if err != nil {
+			return ctx.ThrowInternalError("paginate: %v", err)
+		}
+		b, err := json.Marshal(items)
+		if err != nil {
+			return ctx.ThrowInternalError("paginate: %v", err)
+		}
+		return ctx.ParseJSON(string(b))
+	}
+}
+
+func fetchAllPages(pageURL string, pagination *Pagination, fetchPage func(string) (*request.Response, error)) ([]any, error) {
+	items := []any{}
+	current := ""
+	if u, err := url.Parse(pageURL); err == nil && pagination.Param != "" {
+		current = u.Query().Get(pagination.Param)
+	}
+	for {
+		resp, err := fetchPage(pageURL)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Status >= 400 {
+			return nil, fmt.Errorf("request failed with status %d: %s", resp.Status, resp.Body)
+		}
+		if resp.ParsedJSON == nil {
+			return nil, errors.New("page is not JSON")
+		}
+		step, err := pagination.step(resp.ParsedJSON, resp.Headers, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range step.items {
+			items = append(items, item)
+			if pagination.MaxItems > 0 && len(items) >= pagination.MaxItems {
+				return items, nil
+			}
+		}
+		next := step.nextURL
+		if next == "" && step.cursor != "" {
+			next, err = withQuery(pageURL, pagination.Param, step.cursor)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if next == "" {
+			return items, nil
+		}
+		if next, err = resolveURL(pageURL, next); err != nil {
+			return nil, err
+		}
+		if next == pageURL {
+			return items, nil
+		}
+		pageURL = next
+		current = step.cursor
+	}
+}
+
+func withQuery(rawURL, key, value string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
@@ -4,34 +4,77 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/oauth"
+	"github.com/patrickjm/api-cli/internal/ratelimit"
 	"github.com/patrickjm/api-cli/internal/request"
 	"github.com/patrickjm/api-cli/internal/secret"
 )
 
 type ExecOptions struct {
-	Provider string
-	Profile  string
-	Command  string
-	Params   map[string]string
-	Env      map[string]string
-	Timeout  time.Duration
+	Provider      string
+	Profile       string
+	Command       string
+	Params        map[string]string
+	Env           map[string]string
+	Timeout       time.Duration
+	Stdout        io.Writer
+	ModuleDir     string
+	AllowPaths    []string
+	Retry         *request.RetryPolicy
+	RateLimitPath string
+	Verbose       bool
+	Debug         bool
+	Stderr        io.Writer
+	CacheDir      string
+	CacheMode     request.CacheMode
+	Network       *request.Network
+	All           bool
+	MaxItems      int
+	OnItem        func(item json.RawMessage) error
+	DryRun        bool
+	// As prints each request as a snippet in this format instead of sending
+	// it, with secrets as <name> placeholders unless InlineSecrets is set.
+	As            string
+	InlineSecrets bool
+	// Record saves every request and response to a cassette file; Replay
+	// serves them from one, matching requests by the Match rules.
+	Record string
+	Replay string
+	Match  []string
+	// Fetch, when set, answers every request in place of the network.
+	Fetch func(spec request.Spec) (*request.Response, error)
+	// Isolated runs without the OS environment and the secret store: env()
+	// only sees Env and secret() only Secrets.
+	Isolated bool
+	Secrets  map[string]string
 }
 
 type ExecResult struct {
 	Status int
 	Body   string
 	JSON   string
+	Binary []byte
+	// DefaultQuery is the command's defaultQuery, a jq filter for the
+	// part of the result worth printing.
+	DefaultQuery string
+	// Columns are the command's default columns for tabular output.
+	Columns []string
 }
 
 type CommandDoc struct {
-	Name string
-	Desc string
-	Args []string
+	Name         string      `json:"name"`
+	Desc         string      `json:"desc,omitempty"`
+	Args         []ArgDoc    `json:"args,omitempty"`
+	Pagination   *Pagination `json:"pagination,omitempty"`
+	DefaultQuery string      `json:"defaultQuery,omitempty"`
+	Columns      []string    `json:"columns,omitempty"`
 }
 
 func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
@@ -41,6 +84,12 @@ func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
 	if opts.Timeout <= 0 {
 		opts.Timeout = 20 * time.Second
 	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
 
 	execTimeout := uint64(opts.Timeout.Seconds())
 	if execTimeout == 0 {
@@ -56,19 +105,59 @@ func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
 	ctx := rt.NewContext()
 	defer ctx.Close()
 
-	ctx.Globals().Set("fetch", ctx.NewFunction(fetchFunc(opts.Timeout)))
-	ctx.Globals().Set("secret", ctx.NewFunction(secretFunc(opts.Provider, opts.Profile)))
-	ctx.Globals().Set("env", ctx.NewFunction(envFunc(opts.Env)))
+	files, err := newSandbox(opts.AllowPaths)
+	if err != nil {
+		return nil, err
+	}
+	fetchCfg := &fetchConfig{
+		timeout:  opts.Timeout,
+		files:    files,
+		provider: opts.Provider,
+		profile:  opts.Profile,
+		mock:     opts.Fetch,
+		isolated: opts.Isolated,
+		secrets:  opts.Secrets,
+	}
+	if fetchCfg.transport, err = NetworkTransport(opts.Provider, opts.Profile, opts.Network); err != nil {
+		return nil, err
+	}
+	if opts.CacheDir != "" {
+		fetchCfg.baseCache = &request.Cache{Dir: opts.CacheDir, Mode: opts.CacheMode}
+	}
+	if opts.DryRun || opts.As != "" {
+		fetchCfg.capture = &capture{w: opts.Stdout, format: opts.As, inline: opts.InlineSecrets}
+	}
+	if fetchCfg.cassette, err = newCassetteMode(opts); err != nil {
+		return nil, err
+	}
+	fetchCfg.log = &debugLog{w: opts.Stderr}
+	switch {
+	case opts.Debug:
+		fetchCfg.log.level = levelDebug
+	case opts.Verbose:
+		fetchCfg.log.level = levelVerbose
+	}
+	ctx.Globals().Set("fetch", ctx.NewFunction(fetchFunc(fetchCfg)))
+	ctx.Globals().Set("paginate", ctx.NewFunction(paginateFunc(fetchCfg)))
+	ctx.Globals().Set("crypto", newCrypto(ctx))
+	ctx.Globals().Set("jwt", newJWT(ctx, &jwtKeys{secret: fetchCfg.secret, files: files}))
+	ctx.Globals().Set("secret", ctx.NewFunction(secretFunc(fetchCfg)))
+	ctx.Globals().Set("console", newConsole(ctx, fetchCfg.log))
+	ctx.Globals().Set("env", ctx.NewFunction(envFunc(opts.Env, !opts.Isolated)))
 	ctx.Globals().Set("sleep", ctx.NewFunction(sleepFunc()))
+	ctx.Globals().Set("write", ctx.NewFunction(writeFunc(opts.Stdout)))
+	ctx.Globals().Set("readFile", ctx.NewFunction(readFileFunc(files)))
+	ctx.Globals().Set("writeFile", ctx.NewFunction(writeFileFunc(files)))
+	ctx.Globals().Set("listDir", ctx.NewFunction(listDirFunc(files)))
 	ctx.Globals().Set("provider", ctx.NewString(opts.Provider))
 	ctx.Globals().Set("profile", ctx.NewString(opts.Profile))
 	ctx.Globals().Set("params", mapToObject(ctx, opts.Params))
+	if err := installForms(ctx); err != nil {
+		return nil, err
+	}
 
-	source := prepareScript(string(script))
-	val := ctx.Eval(source)
-	defer val.Free()
-	if val.IsException() {
-		return nil, ctx.Exception()
+	if err := loadScript(ctx, script, opts.ModuleDir); err != nil {
+		return nil, err
 	}
 	defaultVal := ctx.Globals().Get("__api_default__")
 	defer defaultVal.Free()
@@ -78,14 +167,79 @@ func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
 	if !defaultVal.IsObject() {
 		return nil, errors.New("default export must be an object")
 	}
+	limiter, err := rateLimiter(defaultVal, opts, fetchCfg.log)
+	if err != nil {
+		return nil, err
+	}
+	fetchCfg.limiter = limiter
+	send := ctx.NewFunction(sendFunc(fetchCfg))
+	fetchCfg.hooks, err = hooksFromValue(ctx, defaultVal, send)
+	send.Free()
+	if err != nil {
+		return nil, err
+	}
+	if fetchCfg.hooks != nil {
+		defer fetchCfg.hooks.free()
+	}
+	oauthCfg, err := oauthFromValue(defaultVal)
+	if err != nil {
+		return nil, err
+	}
+	if oauthCfg != nil {
+		oauthCfg.Transport = fetchCfg.transport
+		oauthCfg.Timeout = opts.Timeout
+		fetchCfg.auth = &providerAuth{config: oauthCfg, provider: opts.Provider, profile: opts.Profile, log: fetchCfg.log}
+		switch {
+		case opts.As != "" && !opts.InlineSecrets, opts.Replay != "", opts.Isolated:
+			fetchCfg.auth.placeholder = "<" + oauth.TokenSecret + ">"
+		case opts.As == "" && opts.DryRun:
+			fetchCfg.auth.placeholder = redacted
+		}
+	}
 
-	resultVal, err := invokeCommand(ctx, defaultVal, opts.Command, opts.Params)
+	if opts.All {
+		result, err := runAllPages(ctx, defaultVal, opts, fetchCfg)
+		if err == nil {
+			err = fetchCfg.replayMiss()
+		}
+		if err != nil {
+			return result, err
+		}
+		entry := defaultVal.Get(opts.Command)
+		defer entry.Free()
+		if result.Columns, err = columnsFromValue(entry); err != nil {
+			return nil, commandError(opts, err)
+		}
+		return result, nil
+	}
+	resultVal, err := invokeCommand(ctx, defaultVal, opts, fetchCfg)
 	if err != nil {
 		return nil, err
 	}
 	defer resultVal.Free()
+	if err := fetchCfg.replayMiss(); err != nil {
+		return nil, err
+	}
 
+	res := resultFromValue(resultVal)
+	entry := defaultVal.Get(opts.Command)
+	defer entry.Free()
+	if res.DefaultQuery, err = defaultQueryFromValue(entry); err == nil {
+		res.Columns, err = columnsFromValue(entry)
+	}
+	if err != nil {
+		return nil, commandError(opts, err)
+	}
+	return res, nil
+}
+
+// resultFromValue converts the value returned by run() into an ExecResult.
+func resultFromValue(resultVal *quickjs.Value) *ExecResult {
 	res := &ExecResult{}
+	if b, ok := bytesFromValue(resultVal); ok {
+		res.Binary = b
+		return res
+	}
 	if resultVal.IsObject() {
 		statusVal := resultVal.Get("status")
 		bodyVal := resultVal.Get("body")
@@ -96,10 +250,18 @@ func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
 		if !statusVal.IsUndefined() {
 			res.Status = int(statusVal.ToInt32())
 		}
+		if b, ok := bytesFromValue(bodyVal); ok {
+			res.Binary = b
+			return res
+		}
 		if !bodyVal.IsUndefined() && !bodyVal.IsNull() {
 			res.Body = bodyVal.ToString()
 		}
-		if !jsonVal.IsUndefined() && !jsonVal.IsNull() {
+		if jsonVal.IsFunction() {
+			if json.Valid([]byte(res.Body)) {
+				res.JSON = res.Body
+			}
+		} else if !jsonVal.IsUndefined() && !jsonVal.IsNull() {
 			res.JSON = jsonVal.JSONStringify()
 		}
 	}
@@ -112,11 +274,11 @@ func Execute(script []byte, opts ExecOptions) (*ExecResult, error) {
 		res.Body = resultVal.ToString()
 	}
 
-	return res, nil
+	return res
 }
 
-func ListCommands(script []byte) ([]string, error) {
-	docs, err := DescribeCommands(script)
+func ListCommands(script []byte, moduleDir string) ([]string, error) {
+	docs, err := DescribeCommands(script, moduleDir)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +289,51 @@ func ListCommands(script []byte) ([]string, error) {
 	return out, nil
 }
 
-func DescribeCommands(script []byte) ([]CommandDoc, error) {
+func DescribeCommands(script []byte, moduleDir string) ([]CommandDoc, error) {
+	var out []CommandDoc
+	err := withDefaultExport(script, moduleDir, func(ctx *quickjs.Context, defaultVal *quickjs.Value) error {
+		keysFn := ctx.Eval("(obj) => Object.keys(obj || {})")
+		defer keysFn.Free()
+		if keysFn.IsException() {
+			return exceptionError(ctx)
+		}
+		keys := keysFn.Execute(ctx.NewUndefined(), defaultVal)
+		defer keys.Free()
+		if keys.IsException() {
+			return exceptionError(ctx)
+		}
+		if keys.IsArray() {
+			length := keys.Get("length")
+			defer length.Free()
+			for i := int64(0); i < length.ToInt64(); i++ {
+				key := keys.GetIdx(i)
+				name := key.ToString()
+				key.Free()
+				if providerKeys[name] {
+					continue
+				}
+
+				entry := defaultVal.Get(name)
+				if entry == nil || entry.IsUndefined() || entry.IsNull() {
+					continue
+				}
+				doc, err := describeCommand(entry, name)
+				if err != nil {
+					entry.Free()
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				entry.Free()
+				out = append(out, doc)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// withDefaultExport loads a provider script without host functions and
+// passes its default export to fn, for reading metadata.
+func withDefaultExport(script []byte, moduleDir string, fn func(*quickjs.Context, *quickjs.Value) error) error {
 	rt := quickjs.NewRuntime(
 		quickjs.WithExecuteTimeout(2),
 		quickjs.WithMemoryLimit(64*1024*1024),
@@ -137,82 +343,28 @@ func DescribeCommands(script []byte) ([]CommandDoc, error) {
 	ctx := rt.NewContext()
 	defer ctx.Close()
 
-	source := prepareScript(string(script))
-	val := ctx.Eval(source)
-	defer val.Free()
-	if val.IsException() {
-		return nil, ctx.Exception()
+	if err := installForms(ctx); err != nil {
+		return err
+	}
+	if err := loadScript(ctx, script, moduleDir); err != nil {
+		return err
 	}
 	defaultVal := ctx.Globals().Get("__api_default__")
 	defer defaultVal.Free()
 	if defaultVal.IsUndefined() || defaultVal.IsNull() {
-		return nil, errors.New("script did not set export default")
+		return errors.New("script did not set export default")
 	}
 	if !defaultVal.IsObject() {
-		return nil, errors.New("default export must be an object")
-	}
-	keysFn := ctx.Eval("(obj) => Object.keys(obj || {})")
-	defer keysFn.Free()
-	if keysFn.IsException() {
-		return nil, ctx.Exception()
+		return errors.New("default export must be an object")
 	}
-	keys := keysFn.Execute(ctx.NewUndefined(), defaultVal)
-	defer keys.Free()
-	if keys.IsException() {
-		return nil, ctx.Exception()
-	}
-	var out []CommandDoc
-	if keys.IsArray() {
-		length := keys.Get("length")
-		defer length.Free()
-		for i := int64(0); i < length.ToInt64(); i++ {
-			key := keys.GetIdx(i)
-			name := key.ToString()
-			key.Free()
-
-			entry := defaultVal.Get(name)
-			if entry == nil || entry.IsUndefined() || entry.IsNull() {
-				continue
-			}
-			descVal := entry.Get("desc")
-			argsVal := entry.Get("args")
-			doc := CommandDoc{Name: name}
-			if descVal != nil && !descVal.IsUndefined() && !descVal.IsNull() {
-				doc.Desc = descVal.ToString()
-			}
-			if argsVal != nil && argsVal.IsArray() {
-				length := argsVal.Get("length")
-				for j := int64(0); j < length.ToInt64(); j++ {
-					item := argsVal.GetIdx(j)
-					doc.Args = append(doc.Args, item.ToString())
-					item.Free()
-				}
-				length.Free()
-			}
-			if descVal != nil {
-				descVal.Free()
-			}
-			if argsVal != nil {
-				argsVal.Free()
-			}
-			entry.Free()
-			out = append(out, doc)
-		}
-	}
-	return out, nil
+	return fn(ctx, defaultVal)
 }
 
-func prepareScript(source string) string {
-	if strings.Contains(source, "export default") {
-		return strings.Replace(source, "export default", "globalThis.__api_default__ =", 1)
-	}
-	return source
-}
-
-func invokeCommand(ctx *quickjs.Context, defaultVal *quickjs.Value, command string, params map[string]string) (*quickjs.Value, error) {
+func invokeCommand(ctx *quickjs.Context, defaultVal *quickjs.Value, opts ExecOptions, fetchCfg *fetchConfig) (*quickjs.Value, error) {
+	command := opts.Command
 	entry := defaultVal.Get(command)
 	defer entry.Free()
-	if entry.IsUndefined() || entry.IsNull() {
+	if entry.IsUndefined() || entry.IsNull() || providerKeys[command] {
 		return nil, fmt.Errorf("command not found: %s", command)
 	}
 	fn := entry.Get("run")
@@ -220,16 +372,82 @@ func invokeCommand(ctx *quickjs.Context, defaultVal *quickjs.Value, command stri
 	if fn.IsUndefined() || fn.IsNull() || !fn.IsFunction() {
 		return nil, fmt.Errorf("command missing run(): %s", command)
 	}
-	paramsVal := mapToObject(ctx, params)
+	retry, err := retryFromValue(entry)
+	if err != nil {
+		return nil, commandError(opts, err)
+	}
+	fetchCfg.retry = opts.Retry.Merge(retry)
+	cacheVal := entry.Get("cache")
+	fetchCfg.cache, err = cacheFromValue(cacheVal, fetchCfg.baseCache)
+	cacheVal.Free()
+	if err != nil {
+		return nil, commandError(opts, err)
+	}
+	values, err := commandParams(entry, command, opts.Params)
+	if err != nil {
+		return nil, commandError(opts, err)
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	paramsVal := ctx.ParseJSON(string(encoded))
 	defer paramsVal.Free()
+	ctx.Globals().Set("params", ctx.ParseJSON(string(encoded)))
 	result := fn.Execute(ctx.NewUndefined(), paramsVal)
 	if result.IsException() {
 		defer result.Free()
-		return nil, ctx.Exception()
+		return nil, exceptionError(ctx)
+	}
+	return awaitValue(ctx, result)
+}
+
+// commandError prefixes err with the provider and command name.
+func commandError(opts ExecOptions, err error) error {
+	if opts.Provider != "" {
+		return fmt.Errorf("%s.%s: %w", opts.Provider, opts.Command, err)
+	}
+	return fmt.Errorf("%s: %w", opts.Command, err)
+}
+
+// awaitValue drives the job queue until a Promise settles and returns its
+// fulfilled value. Non-promise values are returned unchanged.
+func awaitValue(ctx *quickjs.Context, val *quickjs.Value) (*quickjs.Value, error) {
+	if !val.IsPromise() {
+		return val, nil
+	}
+	normalize := ctx.Eval("(p) => p.catch((e) => { throw e instanceof Error ? e : new Error(String(e)); })")
+	defer normalize.Free()
+	if normalize.IsException() {
+		val.Free()
+		return nil, exceptionError(ctx)
+	}
+	promise := normalize.Execute(ctx.NewUndefined(), val)
+	val.Free()
+	if promise.IsException() {
+		promise.Free()
+		return nil, exceptionError(ctx)
+	}
+	ctx.Loop()
+	if promise.PromiseState() == quickjs.PromisePending {
+		promise.Free()
+		return nil, errors.New("promise never settled")
+	}
+	result := ctx.Await(promise)
+	if result.IsException() {
+		result.Free()
+		return nil, exceptionError(ctx)
 	}
 	return result, nil
 }
 
+func exceptionError(ctx *quickjs.Context) error {
+	if err := ctx.Exception(); err != nil {
+		return err
+	}
+	return errors.New("script threw a non-error value")
+}
+
 func mapToObject(ctx *quickjs.Context, values map[string]string) *quickjs.Value {
 	obj := ctx.NewObject()
 	for k, v := range values {
@@ -238,67 +456,507 @@ func mapToObject(ctx *quickjs.Context, values map[string]string) *quickjs.Value
 	return obj
 }
 
-func fetchFunc(timeout time.Duration) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+type fetchConfig struct {
+	provider  string
+	profile   string
+	timeout   time.Duration
+	files     *sandbox
+	retry     *request.RetryPolicy
+	limiter   *ratelimit.Limiter
+	log       *debugLog
+	capture   *capture
+	cassette  *cassetteMode
+	hooks     *providerHooks
+	mock      func(request.Spec) (*request.Response, error)
+	isolated  bool
+	secrets   map[string]string
+	transport http.RoundTripper
+	auth      *providerAuth
+	// baseCache is the cache from the CLI flags; cache is baseCache with the
+	// running command's cache metadata applied.
+	baseCache *request.Cache
+	cache     *request.Cache
+}
+
+// cacheFromValue applies a cache setting to base: true opts in to caching
+// by response headers, false disables it, and a duration string ("10m") or
+// number of seconds opts in with that TTL. --cache-only is never
+// overridden, so it cannot fall through to the network.
+func cacheFromValue(val *quickjs.Value, base *request.Cache) (*request.Cache, error) {
+	if base == nil || val == nil || val.IsUndefined() || val.IsNull() || base.Mode == request.CacheOnly {
+		return base, nil
+	}
+	var ttl time.Duration
+	switch {
+	case val.IsBool():
+		if !val.ToBool() {
+			return nil, nil
+		}
+		c := *base
+		c.Store = true
+		return &c, nil
+	case val.IsNumber():
+		ttl = time.Duration(val.ToFloat64() * float64(time.Second))
+	case val.IsString():
+		d, err := time.ParseDuration(val.ToString())
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache ttl %q", val.ToString())
+		}
+		ttl = d
+	default:
+		return nil, errors.New("cache must be a boolean, duration string or number of seconds")
+	}
+	if ttl <= 0 {
+		return nil, errors.New("cache ttl must be positive")
+	}
+	c := *base
+	c.TTL = ttl
+	c.Store = true
+	return &c, nil
+}
+
+// providerKeys are default export keys that configure the provider rather
+// than name a command.
+var providerKeys = map[string]bool{
+	"rateLimit":     true,
+	"oauth":         true,
+	"beforeRequest": true,
+	"afterResponse": true,
+	"onError":       true,
+}
+
+// rateLimiter returns the limiter for the provider's declared rate limit.
+// An API_RATE_LIMIT profile env value overrides the script's rateLimit.
+func rateLimiter(defaultVal *quickjs.Value, opts ExecOptions, log *debugLog) (*ratelimit.Limiter, error) {
+	if opts.RateLimitPath == "" {
+		return nil, nil
+	}
+	value, ok := opts.Env["API_RATE_LIMIT"]
+	if !ok {
+		val := defaultVal.Get("rateLimit")
+		defer val.Free()
+		if !val.IsUndefined() && !val.IsNull() {
+			value = val.ToString()
+		}
+	}
+	limit, err := ratelimit.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if limit.IsZero() {
+		return nil, nil
+	}
+	limiter := ratelimit.New(opts.RateLimitPath, limit)
+	if log.enabled(levelVerbose) {
+		limiter.OnWait = func(d time.Duration) {
+			log.printf(levelVerbose, "rate limit %s: waiting %s\n", limit, d.Round(time.Millisecond))
+		}
+	}
+	return limiter, nil
+}
+
+// retryFromValue reads the retry policy declared on a command entry.
+func retryFromValue(entry *quickjs.Value) (*request.RetryPolicy, error) {
+	val := entry.Get("retry")
+	defer val.Free()
+	if val.IsUndefined() || val.IsNull() {
+		return nil, nil
+	}
+	var policy request.RetryPolicy
+	if err := json.Unmarshal([]byte(val.JSONStringify()), &policy); err != nil {
+		return nil, fmt.Errorf("invalid retry: %w", err)
+	}
+	return &policy, nil
+}
+
+// requestRetry layers the per-call retry option over the command and
+// profile policy, logging attempts in verbose mode.
+func (cfg *fetchConfig) requestRetry(spec request.Spec) *request.RetryPolicy {
+	policy := cfg.retry.Merge(spec.Retry)
+	if policy == nil || !cfg.log.enabled(levelVerbose) {
+		return policy
+	}
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	policy.OnRetry = func(r request.Retry) {
+		reason := fmt.Sprintf("status %d", r.Status)
+		if r.Err != nil {
+			reason = r.Err.Error()
+		}
+		cfg.log.printf(levelVerbose, "retry %d/%d %s %s in %s: %s\n", r.Attempt, r.Attempts, method, redactURL(spec.URL), r.Delay.Round(time.Millisecond), reason)
+	}
+	return policy
+}
+
+// fetchFunc is the fetch global. It goes through the provider's hooks once
+// the default export has been loaded, and waits for async hooks so fetch
+// returns the same response whether or not the hooks are async.
+func fetchFunc(cfg *fetchConfig) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	send := sendFunc(cfg)
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if cfg.hooks == nil {
+			return send(ctx, this, args)
+		}
+		out := cfg.hooks.fetch.Execute(ctx.NewUndefined(), args...)
+		if !out.IsPromise() {
+			return out
+		}
+		ctx.Loop()
+		if out.PromiseState() == quickjs.PromisePending {
+			out.Free()
+			return ctx.ThrowInternalError("fetch hooks never settled")
+		}
+		return ctx.Await(out)
+	}
+}
+
+// sendFunc is the fetch bridge itself.
+func sendFunc(cfg *fetchConfig) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
 	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
 		if len(args) == 0 {
 			return ctx.ThrowInternalError("fetch expects a url or options object")
 		}
-		var spec request.Spec
-		switch len(args) {
-		case 1:
-			if args[0].IsString() {
-				spec.URL = args[0].ToString()
-			} else {
-				var err error
-				spec, err = specFromValue(args[0])
-				if err != nil {
-					return ctx.ThrowInternalError("invalid fetch options: %v", err)
-				}
-			}
-		default:
-			if !args[0].IsString() {
-				return ctx.ThrowInternalError("fetch url must be a string")
-			}
-			spec.URL = args[0].ToString()
-			opts, err := specFromValue(args[1])
-			if err != nil {
-				return ctx.ThrowInternalError("invalid fetch options: %v", err)
-			}
-			if opts.Method != "" {
-				spec.Method = opts.Method
+		spec, optsVal, err := specFromArgs(args)
+		if err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		if err := cfg.prepare(&spec, optsVal); err != nil {
+			return ctx.ThrowInternalError("%v", err)
+		}
+		stream, err := streamFromValue(optsVal)
+		if err != nil {
+			return ctx.ThrowInternalError("invalid fetch options: %v", err)
+		}
+		do := func() (*request.Response, []request.Event, error) {
+			if stream == nil {
+				resp, err := cfg.do(spec)
+				return resp, nil, err
 			}
-			if opts.Headers != nil {
-				spec.Headers = opts.Headers
+			defer stream.free()
+			if cfg.capture != nil || cfg.cassette != nil || cfg.mock != nil {
+				return cfg.bufferedStream(ctx, spec, stream)
 			}
-			if opts.Body != nil {
-				spec.Body = opts.Body
+			start := time.Now()
+			var events []request.Event
+			resp, err := request.Stream(spec, cfg.timeout, func(ev request.Event) error {
+				if stream.onEvent == nil {
+					events = append(events, ev)
+					return nil
+				}
+				return stream.dispatch(ctx, ev)
+			})
+			cfg.log.logFetch(spec, resp, err, start)
+			return resp, events, err
+		}
+		resp, events, err := do()
+		if err != nil {
+			var jsErr *quickjs.Error
+			if errors.As(err, &jsErr) {
+				return ctx.ThrowError(errors.New(jsErr.Message))
 			}
+			return ctx.ThrowInternalError("request failed: %v", err)
+		}
+		return thenableResponse(ctx, resp, events, stream != nil)
+	}
+}
+
+// do sends a request, only prints it in --dry-run and --as modes, hands it
+// to the Fetch hook, or records or replays it with a cassette.
+func (cfg *fetchConfig) do(spec request.Spec) (*request.Response, error) {
+	if cfg.capture != nil {
+		return cfg.captureResponse(spec)
+	}
+	start := time.Now()
+	if cfg.mock != nil {
+		resp, err := cfg.mock(spec)
+		cfg.log.logFetch(spec, resp, err, start)
+		return resp, err
+	}
+	if cfg.cassette != nil && cfg.cassette.replay {
+		resp, err := cfg.replay(spec)
+		cfg.log.logFetch(spec, resp, err, start)
+		return resp, err
+	}
+	resp, err := request.Do(spec, cfg.timeout)
+	cfg.log.logFetch(spec, resp, err, start)
+	if err == nil && cfg.cassette != nil {
+		if err := cfg.record(spec, resp); err != nil {
+			return nil, fmt.Errorf("recording cassette: %w", err)
 		}
-		if spec.URL == "" {
-			return ctx.ThrowInternalError("fetch url is required")
+	}
+	return resp, err
+}
+
+// specFromArgs reads fetch(url), fetch(options) or fetch(url, options)
+// arguments. optsVal is the options object, if any.
+func specFromArgs(args []*quickjs.Value) (request.Spec, *quickjs.Value, error) {
+	var spec request.Spec
+	var optsVal *quickjs.Value
+	switch {
+	case len(args) == 1 && args[0].IsString():
+		spec.URL = args[0].ToString()
+	case len(args) == 1:
+		var err error
+		spec, err = specFromValue(args[0])
+		if err != nil {
+			return request.Spec{}, nil, fmt.Errorf("invalid fetch options: %v", err)
 		}
-		resp, err := request.Do(spec, timeout)
+		optsVal = args[0]
+	default:
+		if !args[0].IsString() {
+			return request.Spec{}, nil, errors.New("fetch url must be a string")
+		}
+		spec.URL = args[0].ToString()
+		opts, err := specFromValue(args[1])
 		if err != nil {
-			return ctx.ThrowInternalError("request failed: %v", err)
+			return request.Spec{}, nil, fmt.Errorf("invalid fetch options: %v", err)
+		}
+		if opts.Method != "" {
+			spec.Method = opts.Method
+		}
+		if opts.Headers != nil {
+			spec.Headers = opts.Headers
 		}
-		obj := ctx.NewObject()
-		obj.Set("status", ctx.NewInt32(int32(resp.Status)))
-		headers := ctx.NewObject()
-		for k, v := range resp.Headers {
-			if len(v) > 0 {
-				headers.Set(k, ctx.NewString(v[0]))
+		if opts.Body != nil {
+			spec.Body = opts.Body
+		}
+		spec.Retry = opts.Retry
+		optsVal = args[1]
+	}
+	if spec.URL == "" {
+		return request.Spec{}, nil, errors.New("fetch url is required")
+	}
+	return spec, optsVal, nil
+}
+
+// prepare applies the sandbox, retry policy, rate limiter, cache and OAuth
+// token to a request, honoring the cache, auth and sigv4 options in optsVal.
+func (cfg *fetchConfig) prepare(spec *request.Spec, optsVal *quickjs.Value) error {
+	if cfg.files != nil {
+		if err := cfg.files.resolveSpec(spec); err != nil {
+			return err
+		}
+	}
+	spec.Retry = cfg.requestRetry(*spec)
+	if cfg.limiter != nil {
+		spec.Limiter = cfg.limiter
+	}
+	spec.Cache = cfg.cache
+	spec.Transport = cfg.transport
+	if optsVal != nil && optsVal.IsObject() {
+		cacheVal := optsVal.Get("cache")
+		cache, err := cacheFromValue(cacheVal, spec.Cache)
+		cacheVal.Free()
+		if err != nil {
+			return fmt.Errorf("invalid fetch options: %v", err)
+		}
+		spec.Cache = cache
+	}
+	signer, err := cfg.sigv4FromValue(optsVal)
+	if err != nil {
+		return err
+	}
+	spec.SigV4 = signer
+	if cfg.auth != nil && signer == nil && authFromValue(optsVal) {
+		if err := cfg.auth.authorize(spec); err != nil {
+			return err
+		}
+	}
+	for name, value := range spec.Headers {
+		if strings.EqualFold(name, "Authorization") {
+			if _, token, ok := strings.Cut(value, " "); ok {
+				value = token
 			}
+			cfg.log.addSecret("token", value)
 		}
-		obj.Set("headers", headers)
-		obj.Set("body", ctx.NewString(string(resp.Body)))
-		if resp.ParsedJSON != nil {
-			b, _ := json.Marshal(resp.ParsedJSON)
-			obj.Set("json", ctx.ParseJSON(string(b)))
-		} else {
-			obj.Set("json", ctx.NewNull())
+	}
+	return nil
+}
+
+// NetworkTransport builds the transport for a profile's network settings,
+// or returns nil to use the default one.
+func NetworkTransport(provider, profile string, network *request.Network) (http.RoundTripper, error) {
+	if network.IsZero() {
+		return nil, nil
+	}
+	var keyPEM []byte
+	if name := network.ClientKeySecret; name != "" {
+		key, err := secret.Get(provider, profile, name)
+		if err != nil {
+			return nil, fmt.Errorf("client key secret not found: %s", name)
 		}
+		keyPEM = []byte(key)
+	}
+	transport, err := network.Transport(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("network settings: %w", err)
+	}
+	return transport, nil
+}
+
+// streamOptions holds the streaming settings read from fetch options. When
+// onEvent is nil the events are collected and returned on the response.
+type streamOptions struct {
+	onEvent *quickjs.Value
+}
+
+func streamFromValue(val *quickjs.Value) (*streamOptions, error) {
+	if val == nil || !val.IsObject() {
+		return nil, nil
+	}
+	streamVal := val.Get("stream")
+	defer streamVal.Free()
+	onEvent := val.Get("onEvent")
+	if onEvent.IsUndefined() || onEvent.IsNull() {
+		onEvent.Free()
+		if !streamVal.ToBool() {
+			return nil, nil
+		}
+		return &streamOptions{}, nil
+	}
+	if !onEvent.IsFunction() {
+		onEvent.Free()
+		return nil, errors.New("onEvent must be a function")
+	}
+	return &streamOptions{onEvent: onEvent}, nil
+}
+
+// dispatch calls onEvent for a single event. Returning false from the callback
+// stops the stream; a thrown exception aborts the request.
+func (s *streamOptions) dispatch(ctx *quickjs.Context, ev request.Event) error {
+	evVal := eventValue(ctx, ev)
+	defer evVal.Free()
+	result := s.onEvent.Execute(ctx.NewUndefined(), evVal)
+	if result.IsException() {
+		result.Free()
+		return exceptionError(ctx)
+	}
+	result, err := awaitValue(ctx, result)
+	if err != nil {
+		return err
+	}
+	defer result.Free()
+	if result.IsBool() && !result.ToBool() {
+		return request.ErrStopStream
+	}
+	return nil
+}
+
+func (s *streamOptions) free() {
+	if s.onEvent != nil {
+		s.onEvent.Free()
+	}
+}
+
+func eventValue(ctx *quickjs.Context, ev request.Event) *quickjs.Value {
+	obj := ctx.NewObject()
+	obj.Set("id", ctx.NewString(ev.ID))
+	obj.Set("event", ctx.NewString(ev.Event))
+	obj.Set("data", ctx.NewString(ev.Data))
+	if ev.Retry > 0 {
+		obj.Set("retry", ctx.NewInt32(int32(ev.Retry)))
+	}
+	if json.Valid([]byte(ev.Data)) {
+		obj.Set("json", ctx.ParseJSON(ev.Data))
+	} else {
+		obj.Set("json", ctx.NewNull())
+	}
+	return obj
+}
+
+func setEvents(ctx *quickjs.Context, obj *quickjs.Value, events []request.Event, streamed bool) {
+	if !streamed {
+		return
+	}
+	arr := ctx.ParseJSON("[]")
+	for i, ev := range events {
+		arr.SetIdx(int64(i), eventValue(ctx, ev))
+	}
+	obj.Set("events", arr)
+}
+
+// thenableResponse returns a plain response that is also a thenable: await
+// fetch(...) and fetch(...).then(...) see a Fetch-style response instead, so
+// scripts written against the Fetch API work whether or not run() is async.
+func thenableResponse(ctx *quickjs.Context, resp *request.Response, events []request.Event, streamed bool) *quickjs.Value {
+	val := responseValue(ctx, resp, false)
+	setEvents(ctx, val, events, streamed)
+	val.Set("then", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		fetchVal := responseValue(ctx, resp, true)
+		setEvents(ctx, fetchVal, events, streamed)
+		promise := ctx.NewPromise(func(resolve, reject func(*quickjs.Value)) {
+			defer fetchVal.Free()
+			resolve(fetchVal)
+		})
+		defer promise.Free()
+		then := promise.Get("then")
+		defer then.Free()
+		return then.Execute(promise, args...)
+	}))
+	return val
+}
+
+// responseValue converts a response into a JS object. Plain responses expose
+// the parsed body as a json property; Fetch-style responses expose text() and
+// json() methods returning Promises instead.
+func responseValue(ctx *quickjs.Context, resp *request.Response, fetchStyle bool) *quickjs.Value {
+	obj := ctx.NewObject()
+	obj.Set("status", ctx.NewInt32(int32(resp.Status)))
+	obj.Set("ok", ctx.NewBool(resp.Status >= 200 && resp.Status <= 299))
+	headers := ctx.NewObject()
+	for k, v := range resp.Headers {
+		if len(v) > 0 {
+			headers.Set(k, ctx.NewString(v[0]))
+		}
+	}
+	obj.Set("headers", headers)
+	binary := !resp.IsText()
+	if binary {
+		obj.Set("body", ctx.NewUint8Array(resp.Body))
+	} else {
+		obj.Set("body", ctx.NewString(string(resp.Body)))
+	}
+	if fetchStyle {
+		body := string(resp.Body)
+		raw := resp.Body
+		obj.Set("arrayBuffer", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+			return ctx.NewPromise(func(resolve, reject func(*quickjs.Value)) {
+				val := ctx.NewArrayBuffer(raw)
+				defer val.Free()
+				resolve(val)
+			})
+		}))
+		obj.Set("text", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+			return ctx.NewPromise(func(resolve, reject func(*quickjs.Value)) {
+				val := ctx.NewString(body)
+				defer val.Free()
+				resolve(val)
+			})
+		}))
+		obj.Set("json", ctx.NewFunction(func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+			return ctx.NewPromise(func(resolve, reject func(*quickjs.Value)) {
+				val := ctx.ParseJSON(body)
+				defer val.Free()
+				if val.IsException() {
+					errVal := ctx.NewError(fmt.Errorf("invalid json body: %v", exceptionError(ctx)))
+					defer errVal.Free()
+					reject(errVal)
+					return
+				}
+				resolve(val)
+			})
+		}))
 		return obj
 	}
+	if resp.ParsedJSON != nil && !binary {
+		b, _ := json.Marshal(resp.ParsedJSON)
+		obj.Set("json", ctx.ParseJSON(string(b)))
+	} else {
+		obj.Set("json", ctx.NewNull())
+	}
+	return obj
 }
 
 func specFromValue(val *quickjs.Value) (request.Spec, error) {
@@ -310,24 +968,75 @@ func specFromValue(val *quickjs.Value) (request.Spec, error) {
 	if err := json.Unmarshal([]byte(payload), &spec); err != nil {
 		return request.Spec{}, err
 	}
+	if val.IsObject() {
+		bodyVal := val.Get("body")
+		defer bodyVal.Free()
+		if b, ok := bytesFromValue(bodyVal); ok {
+			spec.Body = b
+		} else if form, ok, err := formBody(bodyVal); ok {
+			if err != nil {
+				return request.Spec{}, err
+			}
+			spec.Body = form
+		}
+	}
 	return spec, nil
 }
 
-func secretFunc(provider, profile string) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+// bytesFromValue returns the contents of a Uint8Array or ArrayBuffer.
+func bytesFromValue(val *quickjs.Value) ([]byte, bool) {
+	if val == nil || !val.IsObject() {
+		return nil, false
+	}
+	if val.IsUint8Array() || val.IsUint8ClampedArray() {
+		b, err := val.ToUint8Array()
+		return b, err == nil
+	}
+	if val.IsByteArray() {
+		b, err := val.ToByteArray(uint(val.ByteLen()))
+		return b, err == nil
+	}
+	return nil, false
+}
+
+func secretFunc(cfg *fetchConfig) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
 	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
 		if len(args) == 0 {
 			return ctx.ThrowInternalError("secret expects a name")
 		}
-		name := args[0].ToString()
-		val, err := secret.Get(provider, profile, name)
+		val, err := cfg.secret(args[0].ToString())
 		if err != nil {
-			return ctx.ThrowInternalError("secret not found: %s", name)
+			return ctx.ThrowInternalError("%v", err)
 		}
 		return ctx.NewString(val)
 	}
 }
 
-func envFunc(values map[string]string) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+// secret reads a secret of the active profile and registers it for
+// redaction. Replays and isolated runs need no credentials, so there a
+// missing secret reads as its <name> placeholder.
+func (cfg *fetchConfig) secret(name string) (string, error) {
+	val, ok := cfg.lookupSecret(name)
+	if !ok {
+		if cfg.isolated || cfg.cassette != nil && cfg.cassette.replay {
+			return "<" + name + ">", nil
+		}
+		return "", fmt.Errorf("secret not found: %s", name)
+	}
+	cfg.log.addSecret(name, val)
+	return val, nil
+}
+
+func (cfg *fetchConfig) lookupSecret(name string) (string, bool) {
+	if cfg.isolated {
+		val, ok := cfg.secrets[name]
+		return val, ok
+	}
+	val, err := secret.Get(cfg.provider, cfg.profile, name)
+	return val, err == nil
+}
+
+func envFunc(values map[string]string, osFallback bool) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
 	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
 		if len(args) == 0 {
 			return ctx.ThrowInternalError("env expects a name")
@@ -339,7 +1048,7 @@ func envFunc(values map[string]string) func(*quickjs.Context, *quickjs.Value, []
 				val = v
 			}
 		}
-		if val == "" {
+		if val == "" && osFallback {
 			val = os.Getenv(name)
 		}
 		return ctx.NewString(val)
@@ -359,3 +1068,14 @@ func sleepFunc() func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quick
 		return ctx.NewUndefined()
 	}
 }
+
+func writeFunc(w io.Writer) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		for _, arg := range args {
+			if _, err := io.WriteString(w, arg.ToString()); err != nil {
+				return ctx.ThrowInternalError("write failed: %v", err)
+			}
+		}
+		return ctx.NewUndefined()
+	}
+}
@@ -1,11 +1,27 @@
 package runtime
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/patrickjm/api-cli/internal/request"
+	"github.com/patrickjm/api-cli/internal/secret"
 )
 
 type echoResponse struct {
@@ -136,3 +152,1723 @@ func TestExecuteFetchWithOptionsObject(t *testing.T) {
 		t.Fatalf("expected PUT, got %s", payload["method"])
 	}
 }
+
+func TestExecuteAsyncRunAwaitsFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"path": r.URL.Path})
+	}))
+	defer server.Close()
+
+	script := `export default { default: { run: async (params) => {
+  const resp = await fetch(params.base + "/async");
+  if (!resp.ok) throw new Error("unexpected status " + resp.status);
+  const data = await resp.json();
+  return { path: data.path, status: resp.status };
+} } }`
+
+	res, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "default",
+		Params: map[string]string{
+			"base": server.URL,
+		},
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(res.JSON), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload["path"] != "/async" {
+		t.Fatalf("expected /async, got %v", payload["path"])
+	}
+}
+
+func TestExecuteAsyncRunReturnsResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"method": r.Method})
+	}))
+	defer server.Close()
+
+	script := `export default { default: { run: async (params) => fetch(params.base, { method: "DELETE" }) } }`
+
+	res, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "default",
+		Params: map[string]string{
+			"base": server.URL,
+		},
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if res.Status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.Status)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(res.JSON), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload["method"] != "DELETE" {
+		t.Fatalf("expected DELETE, got %s", payload["method"])
+	}
+}
+
+func TestExecuteAsyncHelperFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"path": r.URL.Path})
+	}))
+	defer server.Close()
+
+	// run is not async, but the helper it returns from awaits fetch, so the
+	// response must be Fetch-style regardless of how run is declared.
+	script := `const load = async (url) => {
+  const resp = await fetch(url);
+  return { path: (await resp.json()).path, text: await resp.text(), plain: fetch(url).json.path };
+};
+export default { default: { run: (params) => load(params.base + "/helper") } }`
+
+	res, err := Execute([]byte(script), ExecOptions{Command: "default", Params: map[string]string{"base": server.URL}, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if res.JSON != `{"path":"/helper","text":"{\"path\":\"/helper\"}\n","plain":"/helper"}` {
+		t.Fatalf("expected Fetch-style response from helper, got %s", res.JSON)
+	}
+}
+
+func TestExecuteAsyncRunRejects(t *testing.T) {
+	script := `export default { default: { run: async () => {
+  await new Promise((resolve) => setTimeout(resolve, 1));
+  throw new Error("boom");
+} } }`
+
+	_, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "default",
+		Timeout:  5 * time.Second,
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected rejection error, got %v", err)
+	}
+
+	script = `export default { default: { run: () => Promise.reject("plain") } }`
+	_, err = Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "default",
+		Timeout:  5 * time.Second,
+	})
+	if err == nil || !strings.Contains(err.Error(), "plain") {
+		t.Fatalf("expected rejection error, got %v", err)
+	}
+}
+
+func TestExecuteFetchStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range []string{"Hel", "lo", "!"} {
+			_, _ = w.Write([]byte(`data: {"token":"` + token + `"}` + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\ndata: ignored\n\n"))
+	}))
+	defer server.Close()
+
+	script := `export default {
+  default: { run: (params) => {
+    fetch(params.base, { stream: true, onEvent: (ev) => {
+      if (ev.data === "[DONE]") return false;
+      write(ev.json.token);
+    } });
+  } },
+  collect: { run: (params) => fetch(params.base, { stream: true }).events.length },
+}`
+
+	var out strings.Builder
+	_, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "default",
+		Params: map[string]string{
+			"base": server.URL,
+		},
+		Timeout: 5 * time.Second,
+		Stdout:  &out,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if out.String() != "Hello!" {
+		t.Fatalf("expected streamed output Hello!, got %q", out.String())
+	}
+
+	res, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "collect",
+		Params: map[string]string{
+			"base": server.URL,
+		},
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if res.Body != "5" {
+		t.Fatalf("expected 5 collected events, got %s", res.Body)
+	}
+}
+
+func TestExecuteImportsModules(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "lib"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	files := map[string]string{
+		"lib/util.js": `export const prefix = "hello ";`,
+		"lib/greet.js": `import { prefix } from "./util.js";
+export function greet(name) { return prefix + name; }`,
+		"lib/extra.js": `export const extra = true;`,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	script := `import { greet } from "lib/greet.js";
+import { prefix } from "../lib/util.js";
+
+export default { default: { run: (params) => greet(params.name) + "|" + prefix.trim() } };`
+
+	res, err := Execute([]byte(script), ExecOptions{
+		Provider:  "test",
+		Profile:   "default",
+		Command:   "default",
+		Params:    map[string]string{"name": "world"},
+		Timeout:   5 * time.Second,
+		ModuleDir: dir,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if res.Body != "hello world|hello" {
+		t.Fatalf("unexpected result %q", res.Body)
+	}
+
+	docs, err := DescribeCommands([]byte(script), dir)
+	if err != nil {
+		t.Fatalf("DescribeCommands error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Name != "default" {
+		t.Fatalf("unexpected docs: %+v", docs)
+	}
+
+	_, err = Execute([]byte(`import { nope } from "lib/missing.js";
+export default {};`), ExecOptions{Command: "default", ModuleDir: dir})
+	if err == nil || !strings.Contains(err.Error(), "module not found: lib/missing.js") {
+		t.Fatalf("expected missing module error, got %v", err)
+	}
+
+	// import() is loaded ahead of time when its specifier is a literal,
+	// even when it is the script's only import.
+	dynamic := `export default {
+  default: { run: async (params) => (await import("lib/greet.js")).greet(params.name) },
+  computed: { run: async () => (await import("lib/" + "extra.js")).extra },
+};`
+	res, err = Execute([]byte(dynamic), ExecOptions{Command: "default", Params: map[string]string{"name": "there"}, ModuleDir: dir})
+	if err != nil || res.Body != "hello there" {
+		t.Fatalf("expected dynamic import to load, got %+v (%v)", res, err)
+	}
+	// Computed specifiers are not supported.
+	_, err = Execute([]byte(dynamic), ExecOptions{Command: "computed", ModuleDir: dir})
+	if err == nil || !strings.Contains(err.Error(), "could not load module 'lib/extra.js'") {
+		t.Fatalf("expected computed import() to fail, got %v", err)
+	}
+}
+
+func TestExecuteClassicScripts(t *testing.T) {
+	for name, script := range map[string]string{
+		// Scripts without imports keep running as sloppy-mode classic
+		// scripts, with export default anywhere on a line.
+		"classic": `counter = 0; const base = 40; export default { default: { run: () => { total = base + 2; return total; } } };`,
+		// Named exports without imports still load as a module.
+		"module": `export const base = 40;
+export default { default: { run: () => base + 2 } };`,
+	} {
+		res, err := Execute([]byte(script), ExecOptions{Command: "default"})
+		if err != nil || res.Body != "42" {
+			t.Fatalf("%s: expected 42, got %+v (%v)", name, res, err)
+		}
+	}
+}
+
+func TestDescribeCommandsArgSchema(t *testing.T) {
+	script := `export default {
+  "orders.create": {
+    desc: "Create an order",
+    args: [
+      "note",
+      { name: "symbol", desc: "Ticker", required: true, example: "AAPL" },
+      { name: "qty", description: "Quantity", type: "number", default: 1 },
+      { name: "side", enum: ["buy", "sell"] },
+    ],
+    run: () => null,
+  },
+}`
+
+	docs, err := DescribeCommands([]byte(script), "")
+	if err != nil {
+		t.Fatalf("DescribeCommands error: %v", err)
+	}
+	if len(docs) != 1 || len(docs[0].Args) != 4 {
+		t.Fatalf("unexpected docs: %+v", docs)
+	}
+	args := docs[0].Args
+	if args[0].Name != "note" || args[0].Required {
+		t.Fatalf("unexpected string arg: %+v", args[0])
+	}
+	if args[1].Name != "symbol" || args[1].Desc != "Ticker" || !args[1].Required || args[1].Example != "AAPL" {
+		t.Fatalf("unexpected symbol arg: %+v", args[1])
+	}
+	if args[2].Desc != "Quantity" || args[2].Type != "number" || args[2].Default != float64(1) {
+		t.Fatalf("unexpected qty arg: %+v", args[2])
+	}
+	if len(args[3].Enum) != 2 || args[3].Enum[1] != "sell" {
+		t.Fatalf("unexpected side arg: %+v", args[3])
+	}
+
+	_, err = DescribeCommands([]byte(`export default { bad: { args: [{ desc: "no name" }], run: () => null } }`), "")
+	if err == nil || !strings.Contains(err.Error(), "missing a name") {
+		t.Fatalf("expected missing name error, got %v", err)
+	}
+}
+
+func TestExecuteValidatesAndCoercesParams(t *testing.T) {
+	script := `export default {
+  "orders.create": {
+    args: [
+      { name: "symbol", required: true },
+      { name: "qty", type: "number" },
+      { name: "limit", type: "integer", default: 10 },
+      { name: "extended", type: "boolean" },
+      { name: "take_profit", type: "json" },
+      { name: "symbols", type: "array" },
+      { name: "side", enum: ["buy", "sell"] },
+    ],
+    run: (params) => ({
+      types: [typeof params.symbol, typeof params.qty, typeof params.limit, typeof params.extended],
+      limit: params.limit,
+      extended: params.extended,
+      take_profit: params.take_profit,
+      symbols: params.symbols,
+      global_qty: typeof globalThis.params.qty,
+    }),
+  },
+}`
+
+	run := func(params map[string]string) (*ExecResult, error) {
+		return Execute([]byte(script), ExecOptions{
+			Provider: "alpaca",
+			Profile:  "default",
+			Command:  "orders.create",
+			Params:   params,
+			Timeout:  5 * time.Second,
+		})
+	}
+
+	res, err := run(map[string]string{
+		"symbol":      "AAPL",
+		"qty":         "1.5",
+		"extended":    "true",
+		"take_profit": `{"limit_price": 200}`,
+		"symbols":     "AAPL, MSFT",
+		"side":        "buy",
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	var payload struct {
+		Types      []string       `json:"types"`
+		Limit      float64        `json:"limit"`
+		Extended   bool           `json:"extended"`
+		TakeProfit map[string]any `json:"take_profit"`
+		Symbols    []string       `json:"symbols"`
+		GlobalQty  string         `json:"global_qty"`
+	}
+	if err := json.Unmarshal([]byte(res.JSON), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if strings.Join(payload.Types, ",") != "string,number,number,boolean" || payload.GlobalQty != "number" {
+		t.Fatalf("unexpected param types: %v %s", payload.Types, payload.GlobalQty)
+	}
+	if payload.Limit != 10 || !payload.Extended || payload.TakeProfit["limit_price"] != float64(200) {
+		t.Fatalf("unexpected coerced values: %+v", payload)
+	}
+	if strings.Join(payload.Symbols, "|") != "AAPL|MSFT" {
+		t.Fatalf("unexpected array value: %v", payload.Symbols)
+	}
+
+	cases := []struct {
+		params map[string]string
+		want   string
+	}{
+		{map[string]string{}, `alpaca.orders.create: missing required arg "symbol"`},
+		{map[string]string{"symbol": "AAPL", "bogus": "1"}, `alpaca.orders.create: unknown arg "bogus"`},
+		{map[string]string{"symbol": "AAPL", "side": "hold"}, `alpaca.orders.create: arg "side" must be one of buy, sell`},
+		{map[string]string{"symbol": "AAPL", "qty": "abc"}, `alpaca.orders.create: arg "qty" must be a number`},
+		{map[string]string{"symbol": "AAPL", "extended": "maybe"}, `alpaca.orders.create: arg "extended" must be true or false`},
+		{map[string]string{"symbol": "AAPL", "take_profit": "{"}, `alpaca.orders.create: arg "take_profit" must be valid JSON`},
+	}
+	for _, tc := range cases {
+		_, err := run(tc.params)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Fatalf("expected %q, got %v", tc.want, err)
+		}
+	}
+}
+
+func TestExecuteArgsStrictness(t *testing.T) {
+	script := []byte(`export default {
+  names: { args: ["symbol"], run: (params) => params },
+  strict: { strict: true, args: ["symbol"], run: (params) => params },
+  loose: { strict: false, args: [{ name: "symbol" }], run: (params) => params },
+  level: { args: [{ name: "level", type: "number", enum: [1, 2] }, { name: "tier", enum: [1, 2] }], run: (params) => params },
+}`)
+	for _, tc := range []struct {
+		command string
+		params  map[string]string
+		want    string
+	}{
+		{"names", map[string]string{"symbol": "AAPL", "extra": "1"}, `{"extra":"1","symbol":"AAPL"}`},
+		{"strict", map[string]string{"symbol": "AAPL", "extra": "1"}, `strict: unknown arg "extra"`},
+		{"loose", map[string]string{"extra": "1"}, `{"extra":"1"}`},
+		{"level", map[string]string{"level": "1.0", "tier": "2"}, `{"level":1,"tier":"2"}`},
+		{"level", map[string]string{"level": "3"}, `level: arg "level" must be one of 1, 2`},
+		{"level", map[string]string{"tier": "x"}, `level: arg "tier" must be one of 1, 2`},
+	} {
+		res, err := Execute(script, ExecOptions{Command: tc.command, Params: tc.params})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = res.JSON
+		}
+		if got != tc.want {
+			t.Fatalf("%s %v: expected %s, got %s", tc.command, tc.params, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteArgDefaults(t *testing.T) {
+	script := []byte(`export default {
+  list: {
+    args: [
+      { name: "limit", type: "integer", required: true, default: "10" },
+      { name: "side", enum: ["buy", "sell"], default: "buy" },
+      { name: "raw", default: 5 },
+    ],
+    run: (params) => ({ ...params, limitType: typeof params.limit }),
+  },
+  badType: { args: [{ name: "limit", type: "integer", default: "ten" }], run: (params) => params },
+  badEnum: { args: [{ name: "side", enum: ["buy", "sell"], default: "hold" }], run: (params) => params },
+}`)
+	for _, tc := range []struct {
+		command string
+		params  map[string]string
+		want    string
+	}{
+		{"list", nil, `{"limit":10,"raw":5,"side":"buy","limitType":"number"}`},
+		{"list", map[string]string{"limit": "3"}, `{"limit":3,"raw":5,"side":"buy","limitType":"number"}`},
+		{"badType", nil, `badType: args[0]: arg "limit" default must be an integer`},
+		{"badEnum", nil, `badEnum: args[0]: arg "side" default must be one of buy, sell`},
+	} {
+		res, err := Execute(script, ExecOptions{Command: tc.command, Params: tc.params})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = res.JSON
+		}
+		if got != tc.want {
+			t.Fatalf("%s %v: expected %s, got %s", tc.command, tc.params, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteBinaryResponse(t *testing.T) {
+	image := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff, 0xfe}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]int{"len": len(body), "last": int(body[len(body)-1])})
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(image)
+	}))
+	defer server.Close()
+
+	script := `export default {
+  download: { run: (params) => fetch(params.base) },
+  bytes: { run: async (params) => {
+    const resp = await fetch(params.base);
+    return new Uint8Array(await resp.arrayBuffer());
+  } },
+  upload: { run: (params) => {
+    const resp = fetch(params.base);
+    return fetch(params.base, { method: "POST", body: resp.body });
+  } },
+}`
+
+	for _, command := range []string{"download", "bytes"} {
+		res, err := Execute([]byte(script), ExecOptions{
+			Provider: "test",
+			Profile:  "default",
+			Command:  command,
+			Params:   map[string]string{"base": server.URL},
+			Timeout:  5 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", command, err)
+		}
+		if !bytes.Equal(res.Binary, image) {
+			t.Fatalf("%s: expected binary body %v, got %v", command, image, res.Binary)
+		}
+	}
+
+	res, err := Execute([]byte(script), ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "upload",
+		Params:   map[string]string{"base": server.URL},
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("upload: Execute error: %v", err)
+	}
+	var payload map[string]int
+	if err := json.Unmarshal([]byte(res.JSON), &payload); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if payload["len"] != len(image) || payload["last"] != 0xfe {
+		t.Fatalf("expected uploaded bytes to match, got %v", payload)
+	}
+}
+
+func TestExecuteFormBodies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(path, []byte("png-bytes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			_ = r.ParseForm()
+			_ = json.NewEncoder(w).Encode(map[string]any{"type": r.Header.Get("Content-Type"), "form": r.PostForm})
+			return
+		}
+		out := map[string]any{"fields": r.MultipartForm.Value}
+		for name, headers := range r.MultipartForm.File {
+			f, _ := headers[0].Open()
+			b, _ := io.ReadAll(f)
+			f.Close()
+			out[name] = map[string]string{"filename": headers[0].Filename, "type": headers[0].Header.Get("Content-Type"), "data": string(b)}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer server.Close()
+
+	script := `export default {
+  token: { run: (params) => {
+    const body = new URLSearchParams({ grant_type: "client_credentials" });
+    body.append("scope", "a b");
+    return fetch(params.base, { method: "POST", body });
+  } },
+  query: { run: () => new URLSearchParams("?q=a+b&x=1&x=2").getAll("x").join(",") + "|" + new URLSearchParams({ q: "a&b" }).toString() },
+  upload: { run: (params) => {
+    const form = new FormData();
+    form.append("prompt", "cat");
+    form.append("image", file(params.path));
+    form.append("mask", new Uint8Array([109, 97, 115, 107]), "mask.raw");
+    return fetch(params.base, { method: "POST", body: form });
+  } },
+}`
+	run := func(command string) *ExecResult {
+		t.Helper()
+		res, err := Execute([]byte(script), ExecOptions{
+			Provider:   "test",
+			Profile:    "default",
+			Command:    command,
+			Params:     map[string]string{"base": server.URL, "path": path},
+			Timeout:    5 * time.Second,
+			AllowPaths: []string{dir},
+		})
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", command, err)
+		}
+		return res
+	}
+
+	var token struct {
+		Type string              `json:"type"`
+		Form map[string][]string `json:"form"`
+	}
+	if err := json.Unmarshal([]byte(run("token").JSON), &token); err != nil {
+		t.Fatalf("token: invalid json: %v", err)
+	}
+	if token.Type != "application/x-www-form-urlencoded" || token.Form["grant_type"][0] != "client_credentials" || token.Form["scope"][0] != "a b" {
+		t.Fatalf("token: unexpected request %+v", token)
+	}
+
+	if got := run("query").Body; got != "1,2|q=a%26b" {
+		t.Fatalf("query: unexpected result %q", got)
+	}
+
+	var upload struct {
+		Fields map[string][]string `json:"fields"`
+		Image  map[string]string   `json:"image"`
+		Mask   map[string]string   `json:"mask"`
+	}
+	if err := json.Unmarshal([]byte(run("upload").JSON), &upload); err != nil {
+		t.Fatalf("upload: invalid json: %v", err)
+	}
+	if upload.Fields["prompt"][0] != "cat" {
+		t.Fatalf("upload: unexpected fields %+v", upload.Fields)
+	}
+	if upload.Image["filename"] != "photo.png" || upload.Image["type"] != "image/png" || upload.Image["data"] != "png-bytes" {
+		t.Fatalf("upload: unexpected image part %+v", upload.Image)
+	}
+	if upload.Mask["filename"] != "mask.raw" || upload.Mask["data"] != "mask" {
+		t.Fatalf("upload: unexpected mask part %+v", upload.Mask)
+	}
+}
+
+func TestExecuteFileAccess(t *testing.T) {
+	work := t.TempDir()
+	shared := t.TempDir()
+	outside := t.TempDir()
+	t.Chdir(work)
+	if err := os.WriteFile(filepath.Join(shared, "input.json"), []byte(`{"prompt":"cat"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("nope"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(work, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	script := `export default {
+  copy: { run: (params) => {
+    const input = JSON.parse(readFile(params.shared + "/input.json"));
+    writeFile("out/prompt.txt", input.prompt);
+    writeFile("out/raw.bin", new Uint8Array([0, 255]));
+    const raw = readFile("out/raw.bin", "binary");
+    return { files: listDir("out"), raw: Array.from(raw) };
+  } },
+  escape: { run: (params) => readFile(params.path) },
+}`
+	exec := func(command string, params map[string]string) (*ExecResult, error) {
+		return Execute([]byte(script), ExecOptions{
+			Provider:   "test",
+			Profile:    "default",
+			Command:    command,
+			Params:     params,
+			Timeout:    5 * time.Second,
+			AllowPaths: []string{shared},
+		})
+	}
+
+	res, err := exec("copy", map[string]string{"shared": shared})
+	if err != nil {
+		t.Fatalf("copy: Execute error: %v", err)
+	}
+	if res.JSON != `{"files":[{"name":"prompt.txt","dir":false,"size":3},{"name":"raw.bin","dir":false,"size":2}],"raw":[0,255]}` {
+		t.Fatalf("copy: unexpected result %s", res.JSON)
+	}
+	if b, err := os.ReadFile(filepath.Join(work, "out", "prompt.txt")); err != nil || string(b) != "cat" {
+		t.Fatalf("copy: expected prompt.txt to be written, got %q (%v)", b, err)
+	}
+
+	for _, path := range []string{filepath.Join(outside, "secret.txt"), "../" + filepath.Base(outside) + "/secret.txt", "link/secret.txt"} {
+		_, err := exec("escape", map[string]string{"path": path})
+		if err == nil || !strings.Contains(err.Error(), "outside the allowed directories") {
+			t.Fatalf("%s: expected sandbox error, got %v", path, err)
+		}
+	}
+}
+
+func TestExecuteRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls%3 != 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	script := `export default {
+  meta: { retry: { attempts: 3, delay: 1 }, run: (params) => fetch(params.base).status },
+  call: { run: (params) => fetch(params.base, { retry: { attempts: 3, delay: 1 } }).status },
+  off: { retry: 3, run: (params) => fetch(params.base, { retry: false }).status },
+}`
+	for _, tc := range []struct {
+		command string
+		retry   *request.RetryPolicy
+		want    string
+		calls   int
+	}{
+		{command: "meta", want: "200", calls: 3},
+		{command: "call", want: "200", calls: 3},
+		{command: "off", want: "429", calls: 1},
+		{command: "off", retry: &request.RetryPolicy{Attempts: 5}, want: "429", calls: 1},
+	} {
+		calls = 0
+		var stderr bytes.Buffer
+		res, err := Execute([]byte(script), ExecOptions{
+			Provider: "test",
+			Profile:  "default",
+			Command:  tc.command,
+			Params:   map[string]string{"base": server.URL},
+			Timeout:  5 * time.Second,
+			Retry:    tc.retry,
+			Verbose:  true,
+			Stderr:   &stderr,
+		})
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", tc.command, err)
+		}
+		if res.Body != tc.want || calls != tc.calls {
+			t.Fatalf("%s: expected %s after %d calls, got %s after %d", tc.command, tc.want, tc.calls, res.Body, calls)
+		}
+		if tc.calls == 3 && !strings.Contains(stderr.String(), "retry 2/3 GET "+server.URL) {
+			t.Fatalf("%s: expected retry log, got %q", tc.command, stderr.String())
+		}
+	}
+
+	// Profile policy applies when the command declares none.
+	calls = 0
+	res, err := Execute([]byte(script), ExecOptions{
+		Command: "call",
+		Params:  map[string]string{"base": server.URL},
+		Timeout: 5 * time.Second,
+		Retry:   &request.RetryPolicy{Attempts: 2, Delay: time.Millisecond},
+	})
+	if err != nil || res.Body != "200" || calls != 3 {
+		t.Fatalf("expected per-call attempts to override profile, got %v %v after %d calls", res, err, calls)
+	}
+
+	_, err = Execute([]byte(`export default { bad: { retry: "often", run: () => 1 } }`), ExecOptions{Provider: "test", Command: "bad"})
+	if err == nil || !strings.Contains(err.Error(), "test.bad: invalid retry") {
+		t.Fatalf("expected invalid retry error, got %v", err)
+	}
+}
+
+func TestExecuteRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "7")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  rateLimit: "50/min",
+  ping: { run: (params) => fetch(params.base).body },
+}`)
+	path := filepath.Join(t.TempDir(), "test.default.json")
+	res, err := Execute(script, ExecOptions{
+		Provider:      "test",
+		Profile:       "default",
+		Command:       "ping",
+		Params:        map[string]string{"base": server.URL},
+		Timeout:       5 * time.Second,
+		RateLimitPath: path,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if res.Body != "ok" {
+		t.Fatalf("unexpected body %q", res.Body)
+	}
+	var state struct {
+		Tokens float64 `json:"tokens"`
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected bucket state to be written: %v", err)
+	}
+	if err := json.Unmarshal(b, &state); err != nil || state.Tokens > 7 {
+		t.Fatalf("expected tokens capped by X-RateLimit-Remaining, got %s", b)
+	}
+
+	offPath := filepath.Join(t.TempDir(), "off.json")
+	if _, err := Execute(script, ExecOptions{
+		Command:       "ping",
+		Params:        map[string]string{"base": server.URL},
+		Env:           map[string]string{"API_RATE_LIMIT": "off"},
+		RateLimitPath: offPath,
+	}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if _, err := os.Stat(offPath); !os.IsNotExist(err) {
+		t.Fatalf("expected profile env to disable the limiter, stat err %v", err)
+	}
+
+	if _, err := Execute(script, ExecOptions{Command: "rateLimit"}); err == nil || !strings.Contains(err.Error(), "command not found") {
+		t.Fatalf("expected rateLimit not to run as a command, got %v", err)
+	}
+	docs, err := DescribeCommands(script, "")
+	if err != nil {
+		t.Fatalf("DescribeCommands error: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Name != "ping" {
+		t.Fatalf("expected only the ping command, got %+v", docs)
+	}
+}
+
+func TestExecuteAllPages(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		start := 0
+		switch r.URL.Path {
+		case "/cursor", "/link":
+			if c := q.Get("cursor"); c != "" {
+				start, _ = strconv.Atoi(c)
+			}
+		case "/page":
+			if p := q.Get("page"); p != "" {
+				n, _ := strconv.Atoi(p)
+				start = (n - 1) * 2
+			}
+		case "/offset":
+			start, _ = strconv.Atoi(q.Get("offset"))
+		}
+		end := min(start+2, len(items))
+		page := items[min(start, end):end]
+		w.Header().Set("Content-Type", "application/json")
+		out := map[string]any{"data": page}
+		if end < len(items) {
+			out["next"] = strconv.Itoa(end)
+			if r.URL.Path == "/link" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/link?cursor=%d>; rel="next"`, "http://"+r.Host, end))
+			}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer server.Close()
+
+	script := `export default {
+  cursor: {
+    args: ["base", "cursor"],
+    pagination: { items: "data", next: "next", param: "cursor" },
+    run: (params) => fetch(params.base + "/cursor?cursor=" + (params.cursor || "")).json,
+  },
+  link: {
+    pagination: { style: "link", items: "data", param: "cursor" },
+    run: (params) => fetch(params.base + "/link?cursor=" + (params.cursor || "")),
+  },
+  page: {
+    pagination: { style: "page", items: "data", param: "page" },
+    run: async (params) => (await fetch(params.base + "/page?page=" + (params.page || 1))).json(),
+  },
+  offset: {
+    pagination: { style: "offset", items: "data", param: "offset" },
+    run: (params) => fetch(params.base + "/offset?offset=" + (params.offset || 0)),
+  },
+  single: { run: () => [1] },
+}`
+	run := func(command string, maxItems int) (string, error) {
+		var out bytes.Buffer
+		_, err := Execute([]byte(script), ExecOptions{
+			Provider: "test",
+			Command:  command,
+			Params:   map[string]string{"base": server.URL},
+			Timeout:  5 * time.Second,
+			Stdout:   &out,
+			All:      true,
+			MaxItems: maxItems,
+		})
+		return out.String(), err
+	}
+	for _, command := range []string{"cursor", "link", "page", "offset"} {
+		out, err := run(command, 0)
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", command, err)
+		}
+		if out != "1\n2\n3\n4\n5\n" {
+			t.Fatalf("%s: expected every item as NDJSON, got %q", command, out)
+		}
+	}
+	out, err := run("cursor", 3)
+	if err != nil || out != "1\n2\n3\n" {
+		t.Fatalf("expected --max-items to stop after 3 items, got %q (%v)", out, err)
+	}
+	if _, err := run("single", 0); err == nil || !strings.Contains(err.Error(), "test.single: command does not declare pagination") {
+		t.Fatalf("expected missing pagination error, got %v", err)
+	}
+
+	docs, err := DescribeCommands([]byte(script), "")
+	if err != nil {
+		t.Fatalf("DescribeCommands error: %v", err)
+	}
+	if docs[0].Pagination == nil || docs[0].Pagination.Param != "cursor" || docs[4].Pagination != nil {
+		t.Fatalf("expected pagination in command docs, got %+v", docs)
+	}
+}
+
+func TestExecutePaginate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "Bearer t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			_, _ = w.Write([]byte(`{"results":[{"id":"a"},{"id":"b"}],"next":"/items?cursor=2&limit=2"}`))
+		case "2":
+			if r.URL.Query().Get("limit") != "2" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"results":[{"id":"c"}],"next":null}`))
+		}
+	}))
+	defer server.Close()
+
+	script := `export default {
+  sync: { run: (params) => paginate({ url: params.base + "/items?limit=2", headers: { Authorization: "Bearer t" } }, { style: "link", items: "results", next: "next" }).map((m) => m.id).join(",") },
+  async: { run: async (params) => (await paginate({ url: params.base + "/items", headers: { Authorization: "Bearer t" } }, { style: "link", items: "results", next: "next", maxItems: 1 })).length },
+  denied: { run: (params) => paginate(params.base + "/items", { style: "link", next: "next" }) },
+}`
+	for command, want := range map[string]string{"sync": "a,b,c", "async": "1"} {
+		res, err := Execute([]byte(script), ExecOptions{
+			Command: command,
+			Params:  map[string]string{"base": server.URL},
+			Timeout: 5 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", command, err)
+		}
+		if res.Body != want {
+			t.Fatalf("%s: expected %q, got %q", command, want, res.Body)
+		}
+	}
+	_, err := Execute([]byte(script), ExecOptions{Command: "denied", Params: map[string]string{"base": server.URL}, Timeout: 5 * time.Second})
+	if err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected failed page error, got %v", err)
+	}
+}
+
+func TestExecuteCache(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = fmt.Fprintf(w, "call %d", calls)
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  list: { cache: "1h", run: (params) => fetch(params.base).body },
+  live: { cache: "1h", run: (params) => fetch(params.base + "/live", { cache: false }).body },
+}`)
+	dir := t.TempDir()
+	run := func(command string, mode request.CacheMode) (*ExecResult, error) {
+		return Execute(script, ExecOptions{
+			Command:   command,
+			Params:    map[string]string{"base": server.URL},
+			Timeout:   5 * time.Second,
+			CacheDir:  dir,
+			CacheMode: mode,
+		})
+	}
+	for i := 0; i < 2; i++ {
+		res, err := run("list", request.CacheDefault)
+		if err != nil {
+			t.Fatalf("Execute error: %v", err)
+		}
+		if res.Body != "call 1" {
+			t.Fatalf("expected cached body, got %q", res.Body)
+		}
+	}
+	if res, _ := run("list", request.CacheBypass); res.Body != "call 2" {
+		t.Fatalf("expected --no-cache to fetch, got %q", res.Body)
+	}
+	run("live", request.CacheDefault)
+	if res, _ := run("live", request.CacheDefault); res.Body != "call 4" {
+		t.Fatalf("expected cache: false to fetch every time, got %q", res.Body)
+	}
+	if _, err := run("live", request.CacheOnly); err == nil || !strings.Contains(err.Error(), "not in cache") {
+		t.Fatalf("expected cache-only miss, got %v", err)
+	}
+}
+
+func TestExecuteOAuth(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	tokenCalls := 0
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenCalls++
+			_, _ = w.Write([]byte(`{"access_token":"tok` + strconv.Itoa(tokenCalls) + `","expires_in":3600}`))
+			return
+		}
+		auths = append(auths, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  oauth: { tokenUrl: "` + server.URL + `/token", clientId: "id" },
+  ping: { run: () => fetch(env("BASE") + "/data").body },
+  public: { run: () => fetch(env("BASE") + "/cdn", { auth: false }).body },
+  custom: { run: () => fetch(env("BASE") + "/data", { headers: { authorization: "Key abc" } }).body },
+}`)
+	run := func(command string) {
+		t.Helper()
+		if _, err := Execute(script, ExecOptions{
+			Provider: "test",
+			Profile:  "default",
+			Command:  command,
+			Env:      map[string]string{"BASE": server.URL},
+			Timeout:  5 * time.Second,
+		}); err != nil {
+			t.Fatalf("Execute %s error: %v", command, err)
+		}
+	}
+	run("ping")
+	run("ping")
+	run("public")
+	run("custom")
+	if tokenCalls != 1 {
+		t.Fatalf("expected the stored token to be reused, got %d token requests", tokenCalls)
+	}
+	want := []string{"Bearer tok1", "Bearer tok1", "", "Key abc"}
+	if strings.Join(auths, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected Authorization headers %q", auths)
+	}
+
+	commands, err := DescribeCommands(script, "")
+	if err != nil {
+		t.Fatalf("DescribeCommands error: %v", err)
+	}
+	for _, c := range commands {
+		if c.Name == "oauth" {
+			t.Fatalf("oauth metadata listed as a command")
+		}
+	}
+}
+
+func TestExecuteConsole(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	_ = secret.Set("test", "default", "api_key", "sk-live-1234")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  run: {
+    run: (params) => {
+      console.log("hello", { a: 1 }, 2);
+      console.debug("key is", secret("api_key"));
+      console.warn("careful");
+      fetch(params.base + "/items?access_token=abc123&page=2", { headers: { Authorization: "Bearer " + secret("api_key") } });
+      return "done";
+    },
+  },
+}`)
+	for _, tc := range []struct {
+		name    string
+		verbose bool
+		debug   bool
+		want    []string
+		absent  []string
+	}{
+		{name: "quiet", want: []string{"careful\n"}, absent: []string{"hello", "key is", "fetch "}},
+		{name: "verbose", verbose: true, want: []string{`hello {"a":1} 2`, "careful"}, absent: []string{"key is", "fetch "}},
+		{name: "debug", debug: true, want: []string{
+			"key is [redacted]",
+			"fetch GET " + server.URL + "/items?access_token=[redacted]&page=2 -> 200 in ",
+		}, absent: []string{"sk-live-1234", "abc123"}},
+	} {
+		var stderr bytes.Buffer
+		res, err := Execute(script, ExecOptions{
+			Provider: "test",
+			Profile:  "default",
+			Command:  "run",
+			Params:   map[string]string{"base": server.URL},
+			Timeout:  5 * time.Second,
+			Verbose:  tc.verbose,
+			Debug:    tc.debug,
+			Stderr:   &stderr,
+		})
+		if err != nil || res.Body != "done" {
+			t.Fatalf("%s: unexpected result %v %v", tc.name, res, err)
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(stderr.String(), s) {
+				t.Fatalf("%s: expected %q in %q", tc.name, s, stderr.String())
+			}
+		}
+		for _, s := range tc.absent {
+			if strings.Contains(stderr.String(), s) {
+				t.Fatalf("%s: unexpected %q in %q", tc.name, s, stderr.String())
+			}
+		}
+	}
+}
+
+func TestExecuteDryRun(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	_ = secret.Set("test", "default", "api_key", "sk-live-1234")
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  create: {
+    run: (params) => {
+      const res = fetch(params.base + "/orders?api_key=" + secret("api_key"), {
+        method: "POST",
+        headers: { "APCA-API-KEY-ID": secret("api_key"), Authorization: "Bearer " + secret("api_key") },
+        body: { symbol: "AAPL", qty: 1 },
+      });
+      fetch(params.base + "/orders/1");
+      return { code: res.status, data: res.json };
+    },
+  },
+}`)
+	for _, tc := range []struct {
+		name   string
+		as     string
+		inline bool
+		want   string
+	}{
+		{
+			name: "dry run",
+			want: "POST " + server.URL + "/orders?api_key=[redacted]\n" +
+				"Apca-Api-Key-Id: [redacted]\n" +
+				"Authorization: Bearer [redacted]\n" +
+				"Content-Type: application/json\n" +
+				"\n" + `{"qty":1,"symbol":"AAPL"}` + "\n\n" +
+				"GET " + server.URL + "/orders/1\n",
+		},
+		{
+			name: "curl",
+			as:   "curl",
+			want: "curl -X POST '" + server.URL + "/orders?api_key=<api_key>' \\\n" +
+				"  -H 'Apca-Api-Key-Id: <api_key>' \\\n" +
+				"  -H 'Authorization: Bearer <api_key>' \\\n" +
+				"  -H 'Content-Type: application/json' \\\n" +
+				`  --data-raw '{"qty":1,"symbol":"AAPL"}'` + "\n\n" +
+				"curl '" + server.URL + "/orders/1'\n",
+		},
+		{
+			name:   "httpie inline",
+			as:     "httpie",
+			inline: true,
+			want: "http POST '" + server.URL + "/orders?api_key=sk-live-1234' \\\n" +
+				"  'Apca-Api-Key-Id:sk-live-1234' \\\n" +
+				"  'Authorization:Bearer sk-live-1234' \\\n" +
+				"  'Content-Type:application/json' \\\n" +
+				`  --raw '{"qty":1,"symbol":"AAPL"}'` + "\n\n" +
+				"http GET '" + server.URL + "/orders/1'\n",
+		},
+	} {
+		var stdout bytes.Buffer
+		res, err := Execute(script, ExecOptions{
+			Provider:      "test",
+			Profile:       "default",
+			Command:       "create",
+			Params:        map[string]string{"base": server.URL},
+			Timeout:       5 * time.Second,
+			Stdout:        &stdout,
+			DryRun:        tc.as == "",
+			As:            tc.as,
+			InlineSecrets: tc.inline,
+		})
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", tc.name, err)
+		}
+		if called {
+			t.Fatalf("%s: request reached the server", tc.name)
+		}
+		if res.JSON != `{"code":200,"data":{}}` {
+			t.Fatalf("%s: unexpected synthetic response %s", tc.name, res.JSON)
+		}
+		if stdout.String() != tc.want {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", tc.name, tc.want, stdout.String())
+		}
+	}
+}
+
+func TestExecuteCassette(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	_ = secret.Set("test", "default", "api_key", "sk-live-1234")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"path":%q,"key":%q}`, r.URL.Path, r.Header.Get("X-Api-Key"))
+	}))
+	base := server.URL
+
+	script := []byte(`export default {
+  get: {
+    run: (params) => {
+      const headers = { "X-Api-Key": secret("api_key") };
+      const a = fetch(params.base + "/a?token=" + secret("api_key"), { headers }).json;
+      const b = fetch(params.base + "/b", { headers }).json;
+      if (params.extra) {
+        try { fetch(params.base + "/c"); } catch (e) {}
+      }
+      return [a, b];
+    },
+  },
+}`)
+	path := filepath.Join(t.TempDir(), "get.json")
+	run := func(opts ExecOptions) (*ExecResult, error) {
+		opts.Provider, opts.Profile, opts.Command, opts.Timeout = "test", "default", "get", 5*time.Second
+		if opts.Params == nil {
+			opts.Params = map[string]string{"base": base}
+		}
+		return Execute(script, opts)
+	}
+	recorded, err := run(ExecOptions{Record: path})
+	if err != nil {
+		t.Fatalf("record error: %v", err)
+	}
+	server.Close()
+	b, _ := os.ReadFile(path)
+	if strings.Contains(string(b), "sk-live-1234") || !strings.Contains(string(b), `/a?token=<api_key>`) {
+		t.Fatalf("cassette not scrubbed:\n%s", b)
+	}
+
+	// Replays need neither the server nor the secrets.
+	secret.SetStore(secret.NewMemoryStore())
+	replayed, err := run(ExecOptions{Replay: path, Match: []string{"method", "url", "header:X-Api-Key"}})
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+	want := `[{"key":"<api_key>","path":"/a"},{"key":"<api_key>","path":"/b"}]`
+	if replayed.JSON != want || !strings.Contains(recorded.JSON, "sk-live-1234") {
+		t.Fatalf("expected %s, got %s (recorded %s)", want, replayed.JSON, recorded.JSON)
+	}
+
+	_, err = run(ExecOptions{Replay: path, Params: map[string]string{"base": base, "extra": "1"}})
+	if err == nil || !strings.Contains(err.Error(), "no interaction matching GET "+base+"/c") {
+		t.Fatalf("expected unmatched request error, got %v", err)
+	}
+	if _, err := run(ExecOptions{Replay: path, Match: []string{"verb"}}); err == nil {
+		t.Fatal("expected invalid match rule error")
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	_ = secret.Set("shop", "test", "api_key", "sk-live-1234")
+	t.Setenv("SHOP_REGION", "eu")
+
+	script := []byte(`export default {
+  "orders.list": {
+    args: { limit: { type: "number", default: 10 } },
+    run: (params) =>
+      fetch("https://api.shop.test/v1/orders?limit=" + params.limit, {
+        headers: { Authorization: "Bearer " + secret("api_key") },
+      }),
+  },
+  "orders.create": {
+    run: (params) =>
+      fetch((env("SHOP_URL") || "https://api.shop.test") + "/v1/orders", {
+        method: "POST",
+        body: { symbol: params.symbol, region: env("SHOP_REGION") || "us" },
+      }).json,
+  },
+}`)
+	source := []byte(`
+describe("orders", () => {
+  let calls;
+  beforeEach(() => {
+    calls = mockFetch("GET /v1/orders?limit=2", { json: [{ id: 1 }, { id: 2 }] });
+  });
+
+  it("lists orders", () => {
+    const orders = run("orders.list", { limit: 2 });
+    expect(orders).toHaveLength(2);
+    expect(calls.calls[0].headers.Authorization).toBe("Bearer <api_key>");
+  });
+
+  it("uses fake secrets and env", () => {
+    const orders = run("orders.list", { limit: 2 }, { secrets: { api_key: "fake" } });
+    expect(orders[1]).toEqual({ id: 2 });
+    expect(calls.calls[0].headers.Authorization).toBe("Bearer fake");
+    const create = mockFetch("POST https://mock.test/v1/orders", (req) => ({ status: 201, json: req.json }));
+    const created = run("orders.create", { symbol: "AAPL" }, { env: { SHOP_URL: "https://mock.test" } });
+    expect(created).toEqual({ region: "us", symbol: "AAPL" });
+    expect(create.calls).toHaveLength(1);
+  });
+
+  it("reports errors", () => {
+    mockFetch("/v1/orders", { status: 500, body: "boom" });
+    expect(() => run("orders.list", { limit: 2 })).toThrow("status 500");
+  });
+
+  it("fails unmocked requests", () => {
+    run("orders.list", { limit: 3 });
+  });
+
+  it("fails expectations", async () => {
+    await Promise.resolve();
+    expect(run("orders.list", { limit: 2 })).not.toHaveLength(2);
+  });
+
+  it.skip("is skipped", () => {
+    throw new Error("ran");
+  });
+});
+
+test("top level", () => expect("abc").toMatch(/b/));
+`)
+	results, err := RunTests(source, "shop.test.js", TestOptions{Provider: "shop", Script: script, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("RunTests error: %v", err)
+	}
+	want := []struct{ suite, name, failure string }{
+		{"orders", "lists orders", ""},
+		{"orders", "uses fake secrets and env", ""},
+		{"orders", "reports errors", ""},
+		{"orders", "fails unmocked requests", "no mockFetch matches GET https://api.shop.test/v1/orders?limit=3"},
+		{"orders", "fails expectations", `expected [{"id":1},{"id":2}] not to have length 2`},
+		{"orders", "is skipped", ""},
+		{"", "top level", ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), results)
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Suite != w.suite || r.Name != w.name || !strings.Contains(r.Failure, w.failure) || (w.failure == "") != (r.Failure == "") {
+			t.Fatalf("result %d: expected %+v, got %+v", i, w, r)
+		}
+	}
+	if !results[5].Skipped {
+		t.Fatalf("expected skipped test, got %+v", results[5])
+	}
+
+	if _, err := RunTests([]byte(`describe("x", () => { throw new Error("bad file"); });`), "bad.test.js", TestOptions{Script: script}); err == nil || !strings.Contains(err.Error(), "bad file") {
+		t.Fatalf("expected load error, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, []TestFile{{Name: "shop.test.js", Results: results}}); err != nil {
+		t.Fatalf("WriteJUnit error: %v", err)
+	}
+	for _, s := range []string{
+		`<testsuites tests="7" failures="2" skipped="1">`,
+		`<testcase name="lists orders" classname="shop.test.js &gt; orders"`,
+		`<failure message="InternalError: request failed: no mockFetch matches`,
+		`<skipped></skipped>`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("JUnit output missing %s:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestExecuteHooksPaginate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page") {
+		case "":
+			_, _ = io.WriteString(w, `{"items":["a"],"next":"2"}`)
+		case "2":
+			_, _ = io.WriteString(w, `{"items":["b"],"next":"3"}`)
+		default:
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	// afterResponse sees every page, async or not, and onError recovers
+	// from the last page dropping the connection.
+	for name, hooks := range map[string]string{
+		"sync": `afterResponse(resp) {
+    seen.push(resp.status);
+    return { ...resp.json, items: resp.json.items.map((i) => i + "!") };
+  },
+  onError(err) {
+    if (err.message.includes("request failed")) return { items: ["offline"] };
+  },`,
+		"async": `async afterResponse(resp) {
+    await Promise.resolve();
+    seen.push(resp.status);
+    return { ...resp.json, items: resp.json.items.map((i) => i + "!") };
+  },
+  async onError(err) {
+    if (err.message.includes("request failed")) return { items: ["offline"] };
+  },`,
+	} {
+		script := []byte(`const seen = [];
+export default {
+  ` + hooks + `
+  list: { run: async () => ({ items: await paginate(params.base, { items: "items", next: "next", param: "page" }), seen }) },
+}`)
+		res, err := Execute(script, ExecOptions{Command: "list", Params: map[string]string{"base": server.URL}, Timeout: 5 * time.Second})
+		if err != nil || res.JSON != `{"items":["a!","b!","offline"],"seen":[200,200]}` {
+			t.Fatalf("%s: expected hooks on every page, got %+v (%v)", name, res, err)
+		}
+	}
+}
+
+func TestRunTestsStreaming(t *testing.T) {
+	// Nothing listens on port 9, so a request that escapes the mock fails.
+	script := []byte(`export default {
+  chat: {
+    run: () => {
+      const tokens = [];
+      fetch("http://127.0.0.1:9/chat", { method: "POST", body: { q: "hi" }, stream: true, onEvent: (ev) => { tokens.push(ev.data); } });
+      return tokens;
+    },
+  },
+  events: { run: () => fetch("http://127.0.0.1:9/chat", { stream: true }).events.map((ev) => ev.data) },
+}`)
+	source := []byte(`
+const sse = { headers: { "Content-Type": "text/event-stream" }, body: "data: Hel\n\ndata: lo\n\n" };
+it("streams with onEvent", () => {
+  const calls = mockFetch("POST /chat", sse);
+  expect(run("chat")).toEqual(["Hel", "lo"]);
+  expect(calls.calls).toHaveLength(1);
+});
+it("collects events", () => {
+  mockFetch("GET /chat", sse);
+  expect(run("events")).toEqual(["Hel", "lo"]);
+});
+`)
+	results, err := RunTests(source, "chat.test.js", TestOptions{Provider: "chat", Script: script, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("RunTests error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	for _, r := range results {
+		if r.Failure != "" {
+			t.Fatalf("%s: %s", r.Name, r.Failure)
+		}
+	}
+}
+
+func TestExecuteHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			_, _ = io.WriteString(w, "tok")
+		case "/fail":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"message":"bad symbol"}`)
+		case "/items":
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("page") == "" {
+				_, _ = fmt.Fprintf(w, `{"items":[%q],"next":"2"}`, r.Header.Get("Authorization"))
+				return
+			}
+			_, _ = io.WriteString(w, `{"items":["last"]}`)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"auth":%q,"agent":%q}`, r.Header.Get("Authorization"), r.Header.Get("User-Agent"))
+		}
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  beforeRequest(req) {
+    if (req.url.endsWith("/swap")) return { url: params.base + "/echo", headers: { "User-Agent": "swapped" } };
+    const token = fetch(params.base + "/token").body;
+    req.headers.Authorization = "Bearer " + token;
+    req.headers["User-Agent"] = req.headers["User-Agent"] || "shop/1.0";
+  },
+  afterResponse(resp) {
+    if (resp.status >= 400) {
+      const err = new Error(resp.json.message);
+      err.status = resp.status;
+      throw err;
+    }
+    if (resp.json && "auth" in resp.json) return { ...resp.json, wrapped: true };
+  },
+  onError(err) {
+    if (err.message.includes("connection refused")) return { status: 503, json: { offline: true } };
+  },
+  sync: { run: () => [fetch(params.base + "/echo"), fetch({ url: params.base + "/echo", headers: { "User-Agent": "mine" } }), fetch(params.base + "/swap")] },
+  normalized: {
+    run: () => {
+      try {
+        fetch(params.base + "/fail");
+      } catch (e) {
+        return { message: e.message, code: e.status };
+      }
+    },
+  },
+  offline: { run: () => fetch("http://127.0.0.1:1/").json },
+  pages: { run: () => paginate(params.base + "/items", { items: "items", next: "next", param: "page" }) },
+}`)
+	run := func(command string) (*ExecResult, error) {
+		return Execute(script, ExecOptions{Command: command, Params: map[string]string{"base": server.URL}, Timeout: 5 * time.Second})
+	}
+	for _, tc := range []struct{ command, want string }{
+		{"sync", `[{"agent":"shop/1.0","auth":"Bearer tok","wrapped":true},{"agent":"mine","auth":"Bearer tok","wrapped":true},{"agent":"swapped","auth":"","wrapped":true}]`},
+		{"normalized", `{"message":"bad symbol","code":400}`},
+		{"offline", `{"offline":true}`},
+		{"pages", `["Bearer tok","last"]`},
+	} {
+		res, err := run(tc.command)
+		if err != nil {
+			t.Fatalf("%s: Execute error: %v", tc.command, err)
+		}
+		if res.JSON != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.command, tc.want, res.JSON)
+		}
+	}
+
+	// Hooks may be async and see the same response fetch returns. A fetch
+	// made after the hook's first await still skips the hooks, and fetch
+	// waits for the hooks so a sync run() gets a response, not a Promise.
+	async := []byte(`export default {
+  async beforeRequest(req) {
+    await Promise.resolve();
+    const token = await (await fetch(params.base + "/token")).text();
+    req.headers.Authorization = "Bearer " + token;
+    req.headers["User-Agent"] = "shop/2.0";
+  },
+  async afterResponse(resp) {
+    await Promise.resolve();
+    if (resp.json && "auth" in resp.json) return { ...resp.json, wrapped: true };
+  },
+  get: { run: async () => await fetch(params.base + "/echo") },
+  sync: { run: () => { const resp = fetch(params.base + "/token"); return [resp.status, resp.body]; } },
+}`)
+	for _, tc := range []struct{ command, want string }{
+		{"get", `{"agent":"shop/2.0","auth":"Bearer tok","wrapped":true}`},
+		{"sync", `[200,"tok"]`},
+	} {
+		res, err := Execute(async, ExecOptions{Command: tc.command, Params: map[string]string{"base": server.URL}, Timeout: 5 * time.Second})
+		if err != nil || res.JSON != tc.want {
+			t.Fatalf("async %s: expected %s, got %+v (%v)", tc.command, tc.want, res, err)
+		}
+	}
+
+	commands, err := ListCommands(script, "")
+	if err != nil || strings.Join(commands, ",") != "sync,normalized,offline,pages" {
+		t.Fatalf("expected hooks not to be listed as commands, got %v (%v)", commands, err)
+	}
+	if _, err := Execute([]byte(`export default { onError: "ignore", get: { run: () => 1 } }`), ExecOptions{Command: "get"}); err == nil || !strings.Contains(err.Error(), "onError must be a function") {
+		t.Fatalf("expected invalid hook error, got %v", err)
+	}
+}
+
+func TestExecuteDefaultQuery(t *testing.T) {
+	script := []byte(`export default {
+  ask: { defaultQuery: ".answer", run: () => ({ answer: "42", usage: 1 }) },
+  bad: { defaultQuery: 1, run: () => ({}) },
+}`)
+	res, err := Execute(script, ExecOptions{Command: "ask"})
+	if err != nil || res.DefaultQuery != ".answer" || res.JSON != `{"answer":"42","usage":1}` {
+		t.Fatalf("expected full result and default query, got %+v (%v)", res, err)
+	}
+	commands, err := DescribeCommands([]byte(`export default { ask: { defaultQuery: ".answer", run: () => 1 } }`), "")
+	if err != nil || len(commands) != 1 || commands[0].DefaultQuery != ".answer" {
+		t.Fatalf("expected defaultQuery in docs, got %+v (%v)", commands, err)
+	}
+	if _, err := Execute(script, ExecOptions{Command: "bad"}); err == nil || !strings.Contains(err.Error(), "defaultQuery must be a string") {
+		t.Fatalf("expected invalid defaultQuery error, got %v", err)
+	}
+}
+
+func TestExecuteColumns(t *testing.T) {
+	script := []byte(`export default {
+  list: { columns: ["symbol", "qty"], run: () => [{ symbol: "AAPL", qty: "1" }] },
+  bad: { columns: "symbol", run: () => [] },
+}`)
+	res, err := Execute(script, ExecOptions{Command: "list"})
+	if err != nil || !slices.Equal(res.Columns, []string{"symbol", "qty"}) {
+		t.Fatalf("expected columns, got %+v (%v)", res, err)
+	}
+	commands, err := DescribeCommands([]byte(`export default { list: { columns: ["symbol"], run: () => [] } }`), "")
+	if err != nil || len(commands) != 1 || !slices.Equal(commands[0].Columns, []string{"symbol"}) {
+		t.Fatalf("expected columns in docs, got %+v (%v)", commands, err)
+	}
+	if _, err := Execute(script, ExecOptions{Command: "bad"}); err == nil || !strings.Contains(err.Error(), "columns must be an array of strings") {
+		t.Fatalf("expected invalid columns error, got %v", err)
+	}
+}
+
+func TestExecuteCrypto(t *testing.T) {
+	script := []byte(`export default {
+  sign: {
+    run: () => ({
+      sha256: crypto.sha256("abc"),
+      sha512: crypto.sha512("abc", "base64").slice(0, 12),
+      hmac: crypto.hmac("sha256", "key", "The quick brown fox jumps over the lazy dog"),
+      hmacBytes: crypto.hmac("sha512", crypto.fromBase64("a2V5"), crypto.concat("The quick ", "brown fox jumps over the lazy dog"), "hex").slice(0, 16),
+      b64: crypto.toBase64("hi?>"),
+      b64url: crypto.toBase64Url("hi?>"),
+      hex: crypto.toHex(crypto.fromHex("cafe")),
+      utf8: crypto.fromBase64("aGVsbG8", "utf8"),
+      random: crypto.randomBytes(16).length,
+      uuid: crypto.randomUUID(),
+      uuid7: crypto.uuidv7(),
+      equal: crypto.timingSafeEqual("abc", "abc") && !crypto.timingSafeEqual("abc", "abd"),
+    }),
+  },
+}`)
+	res, err := Execute(script, ExecOptions{Command: "sign", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal([]byte(res.JSON), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %v", res.JSON, err)
+	}
+	want := map[string]any{
+		"sha256":    "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":    "3a81oZNherrM",
+		"hmac":      "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		"hmacBytes": "b42af09057bac1e2",
+		"b64":       "aGk/Pg==",
+		"b64url":    "aGk_Pg",
+		"hex":       "cafe",
+		"utf8":      "hello",
+		"random":    float64(16),
+		"equal":     true,
+	}
+	for k, v := range want {
+		if out[k] != v {
+			t.Fatalf("%s: expected %v, got %v", k, v, out[k])
+		}
+	}
+	uuid, _ := out["uuid"].(string)
+	uuid7, _ := out["uuid7"].(string)
+	if len(uuid) != 36 || uuid[14] != '4' || len(uuid7) != 36 || uuid7[14] != '7' {
+		t.Fatalf("unexpected uuids %q %q", uuid, uuid7)
+	}
+}
+
+func TestExecuteJWT(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	privatePEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	pub, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	publicPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	account, _ := json.Marshal(map[string]string{"private_key": privatePEM, "private_key_id": "abc"})
+	_ = secret.Set("test", "default", "signing_key", privatePEM)
+	_ = secret.Set("test", "default", "service_account", string(account))
+
+	script := []byte(`export default {
+  token: {
+    run: (params) => {
+      const token = jwt.sign({ iss: "me", aud: "you" }, "signing_key", { expiresIn: 60 });
+      const claims = jwt.verify(token, { pem: params.pub });
+      const sa = jwt.decode(jwt.sign({ scope: "read" }, { secret: "service_account" }, "ES256"));
+      let rejected = false;
+      try { jwt.verify(token + "x", "signing_key"); } catch (e) { rejected = true; }
+      return { claims, kid: sa.header.kid, alg: sa.header.alg, rejected };
+    },
+  },
+}`)
+	res, err := Execute(script, ExecOptions{
+		Provider: "test",
+		Profile:  "default",
+		Command:  "token",
+		Params:   map[string]string{"pub": publicPEM},
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	var out struct {
+		Claims   map[string]any `json:"claims"`
+		Kid      string         `json:"kid"`
+		Alg      string         `json:"alg"`
+		Rejected bool           `json:"rejected"`
+	}
+	if err := json.Unmarshal([]byte(res.JSON), &out); err != nil {
+		t.Fatalf("invalid JSON %q: %v", res.JSON, err)
+	}
+	iat, _ := out.Claims["iat"].(float64)
+	exp, _ := out.Claims["exp"].(float64)
+	if out.Claims["iss"] != "me" || exp-iat != 60 {
+		t.Fatalf("unexpected claims %v", out.Claims)
+	}
+	if out.Kid != "abc" || out.Alg != "ES256" || !out.Rejected {
+		t.Fatalf("unexpected result %+v", out)
+	}
+}
+
+func TestExecuteSigV4(t *testing.T) {
+	secret.SetStore(secret.NewMemoryStore())
+	_ = secret.Set("test", "default", "aws_access_key_id", "AKID")
+	_ = secret.Set("test", "default", "aws_secret_access_key", "shh")
+	_ = secret.Set("test", "default", "minio_token", "tok")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("Authorization"), r.Header.Get("X-Amz-Security-Token"), r.Header.Get("X-Amz-Content-Sha256"))
+	}))
+	defer server.Close()
+
+	script := []byte(`export default {
+  put: {
+    run: (params) => fetch(params.base + "/bucket/key", {
+      method: "PUT",
+      body: "data",
+      sigv4: { region: "us-west-2", service: "s3", secrets: { sessionToken: "minio_token" } },
+    }).body,
+  },
+  missing: {
+    run: (params) => fetch(params.base, { sigv4: { region: "us-west-2", service: "s3", secrets: { accessKeyId: "nope" } } }).body,
+  },
+}`)
+	run := func(command string) (*ExecResult, error) {
+		return Execute(script, ExecOptions{
+			Provider: "test",
+			Profile:  "default",
+			Command:  command,
+			Params:   map[string]string{"base": server.URL},
+			Timeout:  5 * time.Second,
+		})
+	}
+	res, err := run("put")
+	if err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	parts := strings.Split(res.Body, "|")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(parts[0], "/us-west-2/s3/aws4_request") {
+		t.Fatalf("unexpected signature %q", res.Body)
+	}
+	if parts[1] != "tok" || parts[2] != "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7" {
+		t.Fatalf("expected session token and payload hash, got %q", res.Body)
+	}
+	if _, err := run("missing"); err == nil || !strings.Contains(err.Error(), "secret not found: nope") {
+		t.Fatalf("expected missing secret error, got %v", err)
+	}
+}
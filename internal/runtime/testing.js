@@ -0,0 +1,186 @@
+// Globals for *.test.js files. Tests are collected by describe/it and run
+// one at a time from Go through __api_run_test__, which clears the fetch
+// mocks first. Commands run through run() ask __api_mock__ for responses.
+(() => {
+  const tests = [];
+  const scopes = [{ name: "", before: [], after: [] }];
+  let mocks = [];
+
+  globalThis.describe = (name, fn) => {
+    scopes.push({ name: String(name), before: [], after: [] });
+    try {
+      fn();
+    } finally {
+      scopes.pop();
+    }
+  };
+  const add = (name, fn, skip) => {
+    const chain = scopes.slice();
+    const suite = chain.map((s) => s.name).filter(Boolean).join(" > ");
+    tests.push({ suite, name: String(name), fn, skip, chain });
+  };
+  globalThis.it = (name, fn) => add(name, fn, false);
+  globalThis.it.skip = (name, fn) => add(name, fn, true);
+  globalThis.test = globalThis.it;
+  globalThis.beforeEach = (fn) => scopes[scopes.length - 1].before.push(fn);
+  globalThis.afterEach = (fn) => scopes[scopes.length - 1].after.push(fn);
+
+  globalThis.__api_tests__ = () => tests.map(({ suite, name, skip }) => ({ suite, name, skip }));
+  globalThis.__api_run_test__ = async (i) => {
+    const t = tests[i];
+    mocks = [];
+    for (const scope of t.chain) {
+      for (const fn of scope.before) await fn();
+    }
+    try {
+      await t.fn();
+    } finally {
+      for (const scope of t.chain.slice().reverse()) {
+        for (const fn of scope.after) await fn();
+      }
+    }
+  };
+
+  // mockFetch("POST /v2/orders", response) answers matching requests. The
+  // pattern is an optional method and a full URL or a path; query params in
+  // it must be present in the request. A function pattern gets the request.
+  // response is { status, headers, body | json }, any other value to send
+  // as JSON, or a function of the request returning either. The returned
+  // handle's calls array collects the matched requests.
+  globalThis.mockFetch = (match, response) => {
+    const mock = { match, response, calls: [] };
+    mocks.push(mock);
+    return mock;
+  };
+
+  const parseQuery = (query) => {
+    const out = {};
+    for (const pair of query.split("&").filter(Boolean)) {
+      const [k, v = ""] = pair.split("=");
+      out[decodeURIComponent(k)] = decodeURIComponent(v.replace(/\+/g, " "));
+    }
+    return out;
+  };
+  const matches = (match, req) => {
+    if (typeof match === "function") return !!match(req);
+    let pattern = String(match).trim();
+    const method = /^([A-Za-z]+)\s+(.*)$/.exec(pattern);
+    if (method) {
+      if (method[1].toUpperCase() !== req.method) return false;
+      pattern = method[2];
+    }
+    const [base, query = ""] = pattern.split("?");
+    const target = base.startsWith("/") ? req.path : req.url.split("?")[0];
+    if (target.replace(/\/$/, "") !== base.replace(/\/$/, "")) return false;
+    const want = parseQuery(query);
+    return Object.keys(want).every((k) => req.query[k] === want[k]);
+  };
+  const responseKeys = ["status", "headers", "body", "json"];
+  const toResponse = (res) => {
+    if (res === undefined || res === null) res = {};
+    const shaped =
+      typeof res === "object" &&
+      !Array.isArray(res) &&
+      Object.keys(res).every((k) => responseKeys.includes(k)) &&
+      (res.status === undefined || typeof res.status === "number");
+    if (!shaped) res = { json: res };
+    const headers = { ...(res.headers || {}) };
+    let body = res.body === undefined ? "" : String(res.body);
+    if (res.json !== undefined) {
+      body = JSON.stringify(res.json);
+      if (!Object.keys(headers).some((k) => k.toLowerCase() === "content-type")) {
+        headers["Content-Type"] = "application/json";
+      }
+    }
+    return { status: res.status ?? 200, headers, body };
+  };
+  globalThis.__api_mock__ = (req) => {
+    for (let i = mocks.length - 1; i >= 0; i--) {
+      const mock = mocks[i];
+      if (!matches(mock.match, req)) continue;
+      mock.calls.push(req);
+      return toResponse(typeof mock.response === "function" ? mock.response(req) : mock.response);
+    }
+    return null;
+  };
+
+  const format = (v) => {
+    if (v === undefined) return "undefined";
+    if (typeof v === "function") return "[Function]";
+    if (v instanceof RegExp) return String(v);
+    try {
+      return JSON.stringify(v) ?? String(v);
+    } catch (e) {
+      return String(v);
+    }
+  };
+  const canonical = (v) => {
+    if (Array.isArray(v)) return v.map(canonical);
+    if (v && typeof v === "object") {
+      return Object.fromEntries(Object.keys(v).sort().map((k) => [k, canonical(v[k])]));
+    }
+    return v;
+  };
+  const equal = (a, b) => Object.is(a, b) || JSON.stringify(canonical(a)) === JSON.stringify(canonical(b));
+  const property = (obj, path) => {
+    let cur = obj;
+    for (const key of String(path).split(".")) {
+      if (cur === null || cur === undefined || !(key in Object(cur))) return { found: false };
+      cur = cur[key];
+    }
+    return { found: true, value: cur };
+  };
+
+  globalThis.expect = (actual) => {
+    const matchers = (negate) => {
+      const check = (pass, what) => {
+        if (!!pass === negate) throw new Error(`expected ${format(actual)} ${negate ? "not " : ""}${what}`);
+      };
+      return {
+        toBe: (v) => check(Object.is(actual, v), `to be ${format(v)}`),
+        toEqual: (v) => check(equal(actual, v), `to equal ${format(v)}`),
+        toContain: (v) =>
+          check(
+            typeof actual === "string" ? actual.includes(v) : Array.isArray(actual) && actual.some((x) => equal(x, v)),
+            `to contain ${format(v)}`,
+          ),
+        toMatch: (re) =>
+          check(typeof actual === "string" && (re instanceof RegExp ? re.test(actual) : actual.includes(re)), `to match ${format(re)}`),
+        toBeTruthy: () => check(actual, "to be truthy"),
+        toBeFalsy: () => check(!actual, "to be falsy"),
+        toBeNull: () => check(actual === null, "to be null"),
+        toBeUndefined: () => check(actual === undefined, "to be undefined"),
+        toBeDefined: () => check(actual !== undefined, "to be defined"),
+        toHaveLength: (n) => check(actual !== null && actual !== undefined && actual.length === n, `to have length ${n}`),
+        toHaveProperty: (path, ...value) => {
+          const found = property(actual, path);
+          const suffix = value.length ? ` equal to ${format(value[0])}` : "";
+          check(found.found && (!value.length || equal(found.value, value[0])), `to have property ${format(path)}${suffix}`);
+        },
+        toBeGreaterThan: (n) => check(actual > n, `to be greater than ${format(n)}`),
+        toBeLessThan: (n) => check(actual < n, `to be less than ${format(n)}`),
+        toThrow: (expected) => {
+          let thrown = false;
+          let message = "";
+          try {
+            actual();
+          } catch (e) {
+            thrown = true;
+            message = e instanceof Error ? e.message : String(e);
+          }
+          const pass =
+            thrown &&
+            (expected === undefined || (expected instanceof RegExp ? expected.test(message) : message.includes(expected)));
+          if (pass === negate) {
+            const what = expected === undefined ? "" : ` ${format(expected)}`;
+            const got = thrown ? `, but it threw ${format(message)}` : "";
+            throw new Error(`expected function ${negate ? "not " : ""}to throw${what}${got}`);
+          }
+        },
+      };
+    };
+    const m = matchers(false);
+    m.not = matchers(true);
+    return m;
+  };
+})();
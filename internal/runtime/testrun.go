@@ -0,0 +1,228 @@
+package runtime
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	quickjs "github.com/buke/quickjs-go"
+	"github.com/patrickjm/api-cli/internal/request"
+)
+
+//go:embed testing.js
+var testingPrelude string
+
+// testFileTimeout bounds a whole test file, including the commands it runs.
+const testFileTimeout = 5 * time.Minute
+
+// TestResult is the outcome of one test in a *.test.js file.
+type TestResult struct {
+	Suite    string
+	Name     string
+	Duration time.Duration
+	// Failure is the error of a failed test, empty when it passed.
+	Failure string
+	Skipped bool
+}
+
+// TestOptions describe the provider a test file exercises.
+type TestOptions struct {
+	Provider  string
+	Script    []byte
+	ModuleDir string
+	Timeout   time.Duration
+	Stderr    io.Writer
+}
+
+// RunTests runs a *.test.js file. Besides describe/it/expect and
+// beforeEach/afterEach, tests get run(command, params, { secrets, env,
+// profile }) to execute a provider command in isolation, and
+// mockFetch(match, response) to answer the requests it makes.
+func RunTests(source []byte, filename string, opts TestOptions) ([]TestResult, error) {
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	rt := quickjs.NewRuntime(
+		quickjs.WithExecuteTimeout(uint64(testFileTimeout.Seconds())),
+		quickjs.WithMemoryLimit(128*1024*1024),
+	)
+	defer rt.Close()
+
+	ctx := rt.NewContext()
+	defer ctx.Close()
+
+	ctx.Globals().Set("console", newConsole(ctx, &debugLog{w: opts.Stderr, level: levelVerbose}))
+	ctx.Globals().Set("run", ctx.NewFunction(testRunFunc(opts)))
+	for _, src := range []struct{ name, code string }{{"<api-test>", testingPrelude}, {filename, string(source)}} {
+		val := ctx.Eval(src.code, quickjs.EvalFileName(src.name))
+		if val.IsException() {
+			val.Free()
+			return nil, fmt.Errorf("%s: %w", src.name, exceptionError(ctx))
+		}
+		val.Free()
+	}
+
+	listVal := ctx.Eval("JSON.stringify(__api_tests__())")
+	defer listVal.Free()
+	var tests []struct {
+		Suite string `json:"suite"`
+		Name  string `json:"name"`
+		Skip  bool   `json:"skip"`
+	}
+	if err := json.Unmarshal([]byte(listVal.ToString()), &tests); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	results := make([]TestResult, len(tests))
+	for i, t := range tests {
+		results[i] = TestResult{Suite: t.Suite, Name: t.Name, Skipped: t.Skip}
+		if t.Skip {
+			continue
+		}
+		start := time.Now()
+		val := ctx.Eval(fmt.Sprintf("__api_run_test__(%d)", i))
+		var err error
+		if val.IsException() {
+			val.Free()
+			err = exceptionError(ctx)
+		} else if val, err = awaitValue(ctx, val); err == nil {
+			val.Free()
+		}
+		results[i].Duration = time.Since(start)
+		if err != nil {
+			results[i].Failure = strings.TrimPrefix(err.Error(), "Error: ")
+		}
+	}
+	return results, nil
+}
+
+// testRunFunc implements run(). It returns the command's JSON result or
+// text and throws when the command fails, as the CLI would.
+func testRunFunc(opts TestOptions) func(*quickjs.Context, *quickjs.Value, []*quickjs.Value) *quickjs.Value {
+	return func(ctx *quickjs.Context, this *quickjs.Value, args []*quickjs.Value) *quickjs.Value {
+		if len(args) == 0 || !args[0].IsString() {
+			return ctx.ThrowInternalError("run expects a command name")
+		}
+		var params map[string]any
+		if len(args) > 1 && args[1].IsObject() {
+			if err := json.Unmarshal([]byte(args[1].JSONStringify()), &params); err != nil {
+				return ctx.ThrowInternalError("run: invalid params: %v", err)
+			}
+		}
+		var options struct {
+			Secrets map[string]string `json:"secrets"`
+			Env     map[string]string `json:"env"`
+			Profile string            `json:"profile"`
+		}
+		if len(args) > 2 && args[2].IsObject() {
+			if err := json.Unmarshal([]byte(args[2].JSONStringify()), &options); err != nil {
+				return ctx.ThrowInternalError("run: invalid options: %v", err)
+			}
+		}
+		if options.Profile == "" {
+			options.Profile = "test"
+		}
+		values := make(map[string]string, len(params))
+		for k, v := range params {
+			if s, ok := v.(string); ok {
+				values[k] = s
+			} else {
+				b, _ := json.Marshal(v)
+				values[k] = string(b)
+			}
+		}
+		var stdout bytes.Buffer
+		res, err := Execute(opts.Script, ExecOptions{
+			Provider:  opts.Provider,
+			Profile:   options.Profile,
+			Command:   args[0].ToString(),
+			Params:    values,
+			Env:       options.Env,
+			Secrets:   options.Secrets,
+			Isolated:  true,
+			ModuleDir: opts.ModuleDir,
+			Timeout:   opts.Timeout,
+			Stdout:    &stdout,
+			Stderr:    opts.Stderr,
+			Fetch:     testFetch(ctx),
+		})
+		if err != nil {
+			return ctx.ThrowError(err)
+		}
+		if res.Status >= 400 {
+			return ctx.ThrowError(fmt.Errorf("request failed with status %d: %s", res.Status, res.Body))
+		}
+		switch {
+		case res.JSON != "":
+			return ctx.ParseJSON(res.JSON)
+		case res.Binary != nil:
+			return ctx.NewUint8Array(res.Binary)
+		}
+		return ctx.NewString(res.Body)
+	}
+}
+
+// testFetch answers a command's requests from the test file's mockFetch
+// registrations, failing requests that no mock matches.
+func testFetch(ctx *quickjs.Context) func(request.Spec) (*request.Response, error) {
+	return func(spec request.Spec) (*request.Response, error) {
+		req, payload, err := request.NewRequest(spec)
+		if err != nil {
+			return nil, err
+		}
+		query := map[string]string{}
+		for k, v := range req.URL.Query() {
+			query[k] = v[0]
+		}
+		headers := map[string]string{}
+		for k, v := range req.Header {
+			headers[k] = strings.Join(v, ", ")
+		}
+		call := map[string]any{
+			"method":  req.Method,
+			"url":     req.URL.String(),
+			"path":    req.URL.Path,
+			"query":   query,
+			"headers": headers,
+			"body":    string(payload),
+		}
+		var parsed any
+		if json.Unmarshal(payload, &parsed) == nil {
+			call["json"] = parsed
+		}
+		b, err := json.Marshal(call)
+		if err != nil {
+			return nil, err
+		}
+		callVal := ctx.ParseJSON(string(b))
+		defer callVal.Free()
+		mock := ctx.Globals().Get("__api_mock__")
+		defer mock.Free()
+		resVal := mock.Execute(ctx.NewUndefined(), callVal)
+		defer resVal.Free()
+		if resVal.IsException() {
+			return nil, exceptionError(ctx)
+		}
+		if resVal.IsNull() {
+			return nil, fmt.Errorf("no mockFetch matches %s %s", req.Method, req.URL)
+		}
+		var res struct {
+			Status  int               `json:"status"`
+			Headers map[string]string `json:"headers"`
+			Body    string            `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(resVal.JSONStringify()), &res); err != nil {
+			return nil, fmt.Errorf("invalid mock response: %w", err)
+		}
+		header := http.Header{}
+		for k, v := range res.Headers {
+			header.Set(k, v)
+		}
+		return request.NewResponse(res.Status, header, []byte(res.Body)), nil
+	}
+}
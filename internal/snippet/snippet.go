@@ -0,0 +1,189 @@
+// Package snippet renders HTTP requests as standalone curl, HTTPie, Go and
+// Python code.
+package snippet
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Snippet formats.
+const (
+	Curl   = "curl"
+	HTTPie = "httpie"
+	Go     = "go"
+	Python = "python"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{Curl, HTTPie, Go, Python}
+
+// Request is a request as it would be sent.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Format renders r in the given format.
+func Format(format string, r Request) (string, error) {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	switch format {
+	case Curl:
+		return curl(r), nil
+	case HTTPie:
+		return httpie(r), nil
+	case Go:
+		return goProgram(r), nil
+	case Python:
+		return python(r), nil
+	}
+	return "", fmt.Errorf("unknown snippet format %q (want %s)", format, strings.Join(Formats, ", "))
+}
+
+// headerLines returns the headers as sorted name/value pairs.
+func headerLines(h http.Header) [][2]string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines [][2]string
+	for _, name := range names {
+		for _, value := range h[name] {
+			lines = append(lines, [2]string{name, value})
+		}
+	}
+	return lines
+}
+
+// shellQuote single-quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// binaryInput pipes a binary body in as base64, so the snippet stays
+// self-contained.
+func binaryInput(body []byte) string {
+	return "printf %s " + shellQuote(base64.StdEncoding.EncodeToString(body)) + " | base64 -d | "
+}
+
+func curl(r Request) string {
+	var b strings.Builder
+	binary := len(r.Body) > 0 && !utf8.Valid(r.Body)
+	if binary {
+		b.WriteString(binaryInput(r.Body))
+	}
+	b.WriteString("curl")
+	if r.Method != http.MethodGet || len(r.Body) > 0 {
+		b.WriteString(" -X " + r.Method)
+	}
+	b.WriteString(" " + shellQuote(r.URL))
+	for _, h := range headerLines(r.Header) {
+		b.WriteString(" \\\n  -H " + shellQuote(h[0]+": "+h[1]))
+	}
+	switch {
+	case binary:
+		b.WriteString(" \\\n  --data-binary @-")
+	case len(r.Body) > 0:
+		b.WriteString(" \\\n  --data-raw " + shellQuote(string(r.Body)))
+	}
+	return b.String()
+}
+
+func httpie(r Request) string {
+	var b strings.Builder
+	binary := len(r.Body) > 0 && !utf8.Valid(r.Body)
+	if binary {
+		b.WriteString(binaryInput(r.Body))
+	}
+	b.WriteString("http " + r.Method + " " + shellQuote(r.URL))
+	for _, h := range headerLines(r.Header) {
+		if h[1] == "" {
+			b.WriteString(" \\\n  " + shellQuote(h[0]+";"))
+		} else {
+			b.WriteString(" \\\n  " + shellQuote(h[0]+":"+h[1]))
+		}
+	}
+	if len(r.Body) > 0 && !binary {
+		b.WriteString(" \\\n  --raw " + shellQuote(string(r.Body)))
+	}
+	return b.String()
+}
+
+func goProgram(r Request) string {
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n\t\"net/http\"\n")
+	if len(r.Body) > 0 {
+		b.WriteString("\t\"strings\"\n")
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+	body := "nil"
+	if len(r.Body) > 0 {
+		b.WriteString("\tbody := strings.NewReader(" + strconv.Quote(string(r.Body)) + ")\n")
+		body = "body"
+	}
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(r.Method), strconv.Quote(r.URL), body)
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	for _, h := range headerLines(r.Header) {
+		fmt.Fprintf(&b, "\treq.Header.Add(%s, %s)\n", strconv.Quote(h[0]), strconv.Quote(h[1]))
+	}
+	b.WriteString("\tresp, err := http.DefaultClient.Do(req)\n")
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	b.WriteString("\tdefer resp.Body.Close()\n")
+	b.WriteString("\tout, _ := io.ReadAll(resp.Body)\n")
+	b.WriteString("\tfmt.Println(resp.Status)\n")
+	b.WriteString("\tfmt.Println(string(out))\n")
+	b.WriteString("}")
+	return b.String()
+}
+
+func python(r Request) string {
+	var b strings.Builder
+	b.WriteString("import requests\n\nresponse = requests.request(\n")
+	fmt.Fprintf(&b, "    %s,\n    %s,\n", pyString(r.Method), pyString(r.URL))
+	if lines := headerLines(r.Header); len(lines) > 0 {
+		b.WriteString("    headers={\n")
+		for _, h := range lines {
+			fmt.Fprintf(&b, "        %s: %s,\n", pyString(h[0]), pyString(h[1]))
+		}
+		b.WriteString("    },\n")
+	}
+	if len(r.Body) > 0 {
+		fmt.Fprintf(&b, "    data=%s,\n", pyBody(r.Body))
+	}
+	b.WriteString(")\nprint(response.status_code)\nprint(response.text)")
+	return b.String()
+}
+
+// pyString quotes s as a Python string literal; JSON string escapes are
+// valid Python.
+func pyString(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func pyBody(body []byte) string {
+	if utf8.Valid(body) {
+		return pyString(string(body))
+	}
+	var b strings.Builder
+	b.WriteString(`b"`)
+	for _, c := range body {
+		fmt.Fprintf(&b, `\x%02x`, c)
+	}
+	b.WriteString(`"`)
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package snippet
+
+import (
+	"go/format"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	req := Request{
+		Method: "POST",
+		URL:    "https://api.example.com/v2/orders?note=it's",
+		Header: http.Header{"Content-Type": {"application/json"}, "Authorization": {"Bearer <token>"}},
+		Body:   []byte(`{"symbol":"AAPL","note":"it's"}`),
+	}
+	for format, want := range map[string]string{
+		Curl: `curl -X POST 'https://api.example.com/v2/orders?note=it'\''s' \
+  -H 'Authorization: Bearer <token>' \
+  -H 'Content-Type: application/json' \
+  --data-raw '{"symbol":"AAPL","note":"it'\''s"}'`,
+		HTTPie: `http POST 'https://api.example.com/v2/orders?note=it'\''s' \
+  'Authorization:Bearer <token>' \
+  'Content-Type:application/json' \
+  --raw '{"symbol":"AAPL","note":"it'\''s"}'`,
+		Python: `import requests
+
+response = requests.request(
+    "POST",
+    "https://api.example.com/v2/orders?note=it's",
+    headers={
+        "Authorization": "Bearer <token>",
+        "Content-Type": "application/json",
+    },
+    data="{\"symbol\":\"AAPL\",\"note\":\"it's\"}",
+)
+print(response.status_code)
+print(response.text)`,
+	} {
+		got, err := Format(format, req)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got != want {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", format, want, got)
+		}
+	}
+
+	for _, r := range []Request{req, {URL: "https://api.example.com/"}} {
+		src, err := Format(Go, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := format.Source([]byte(src))
+		if err != nil || string(formatted) != src+"\n" {
+			t.Fatalf("Go snippet is not gofmt'd source (%v):\n%s", err, src)
+		}
+	}
+
+	if _, err := Format("powershell", req); err == nil {
+		t.Fatal("expected unknown format error")
+	}
+}
+
+func TestFormatBinaryBody(t *testing.T) {
+	req := Request{Method: "PUT", URL: "https://example.com/upload", Body: []byte{0xff, 0x00, 0x01}}
+	got, _ := Format(Curl, req)
+	if got != "printf %s '/wAB' | base64 -d | curl -X PUT 'https://example.com/upload' \\\n  --data-binary @-" {
+		t.Fatalf("unexpected curl snippet %q", got)
+	}
+	got, _ = Format(Python, req)
+	if !strings.Contains(got, `data=b"\xff\x00\x01",`) {
+		t.Fatalf("unexpected python snippet %q", got)
+	}
+}
@@ -0,0 +1,14 @@
+import { parseJSON } from "./http.js";
+
+export function buildMessages(params) {
+  if (typeof params.messages === "string") return parseJSON(params.messages, []);
+  if (params.messages) return params.messages;
+  const out = [];
+  if (params.system) {
+    out.push({ role: "system", content: params.system });
+  }
+  if (params.q) {
+    out.push({ role: "user", content: params.q });
+  }
+  return out;
+}